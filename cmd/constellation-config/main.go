@@ -0,0 +1,144 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Command constellation-config prints the effective configuration of a
+// namespace.
+//
+// Usage:
+//
+//	constellation-config -dir config -namespace foo -format yaml
+//
+// Since the tool does not know the Go types of the schemas, every key that
+// matches one of the -schema patterns is loaded as a generic map.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/database"
+	"github.com/tamasd/constellation/logger/null"
+)
+
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+func main() {
+	var schemas patterns
+	dir := flag.String("dir", "", "base directory of the namespaces")
+	dbUrl := flag.String("database", "", "database connection string")
+	namespace := flag.String("namespace", "", "namespace to dump")
+	format := flag.String("format", "json", "output format: json, yaml or toml")
+	flag.Var(&schemas, "schema", "schema pattern to dump (repeatable, default: *)")
+	flag.Parse()
+
+	if err := run(*dir, *dbUrl, *namespace, *format, schemas); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, dbUrl, namespace, format string, schemas []string) error {
+	if namespace == "" {
+		return fmt.Errorf("the -namespace flag is required")
+	}
+	if len(schemas) == 0 {
+		schemas = []string{"*"}
+	}
+
+	ft, err := fileType(format)
+	if err != nil {
+		return err
+	}
+
+	store := config.NewStore(null.NewLogger())
+	for _, schema := range schemas {
+		store.RegisterSchema(schema, reflect.TypeOf(map[string]interface{}{}))
+	}
+
+	if dir != "" {
+		store.AddCollectionLoaders(config.NewDirectory(dir, nil, true))
+	}
+	if dbUrl != "" {
+		conn, err := database.Connect(dbUrl)
+		if err != nil {
+			return err
+		}
+		store.AddCollectionLoaders(config.NewDatabase(conn, true))
+	}
+
+	dump, err := store.Dump(namespace)
+	if err != nil {
+		return err
+	}
+
+	return ft.Marshal(os.Stdout, normalize(dump))
+}
+
+func fileType(format string) (config.FileType, error) {
+	switch format {
+	case "json":
+		return &config.JSON{Indent: "  "}, nil
+	case "yaml", "yml":
+		return &config.YAML{}, nil
+	case "toml":
+		return &config.TOML{QuoteMapKeys: true}, nil
+	}
+
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+// normalize converts the maps with interface keys produced by the YAML
+// decoder, so every output format can encode them.
+func normalize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = normalize(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[k] = normalize(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(value))
+		for i, item := range value {
+			s[i] = normalize(item)
+		}
+		return s
+	}
+
+	return v
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/config/env"
+)
+
+// MustGet returns the value of a key, and panics on error.
+func MustGet(c Config, key string) interface{} {
+	v, err := c.Get(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// GetString returns a string value of a key or a sub-path of a key.
+func GetString(c Config, path string) (string, error) {
+	var s string
+	err := c.GetInto(path, &s)
+	return s, err
+}
+
+// GetInt returns an integer value of a key or a sub-path of a key.
+func GetInt(c Config, path string) (int, error) {
+	var i int
+	err := c.GetInto(path, &i)
+	return i, err
+}
+
+// GetDuration returns a duration value of a key or a sub-path of a key.
+//
+// Strings are parsed with time.ParseDuration, and numbers are treated as
+// nanoseconds.
+func GetDuration(c Config, path string) (time.Duration, error) {
+	var d time.Duration
+	err := c.GetInto(path, &d)
+	return d, err
+}
+
+func (s *Store) getInto(namespace, path string, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("GetInto requires a non-nil pointer")
+	}
+
+	key, fields := s.resolvePath(path)
+	val, err := s.get(namespace, key)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return &KeyNotFoundError{Namespace: namespace, Key: key}
+	}
+
+	rv := reflect.ValueOf(val)
+	for i, field := range fields {
+		var found bool
+		if rv, found = lookupField(rv, field); !found {
+			return &KeyNotFoundError{
+				Namespace: namespace,
+				Key:       key + "." + strings.Join(fields[:i+1], "."),
+			}
+		}
+	}
+
+	return assignValue(target.Elem(), rv)
+}
+
+// resolvePath splits a path into the longest prefix that has a registered
+// schema, and the remaining field names.
+func (s *Store) resolvePath(path string) (string, []string) {
+	if s.schemas.Get(path) != nil {
+		return path, nil
+	}
+
+	parts := strings.Split(path, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		key := strings.Join(parts[:i], ".")
+		if s.schemas.Get(key) != nil {
+			return key, parts[i:]
+		}
+	}
+
+	return path, nil
+}
+
+func lookupField(rv reflect.Value, name string) (reflect.Value, bool) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return reflect.Value{}, false
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if strings.EqualFold(field.Name, name) || hasTagName(field, name) {
+				return rv.Field(i), true
+			}
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			for k.Kind() == reflect.Interface {
+				k = k.Elem()
+			}
+			if k.Kind() == reflect.String && k.String() == name {
+				return iter.Value(), true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(name)
+		if err == nil && i >= 0 && i < rv.Len() {
+			return rv.Index(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+func hasTagName(field reflect.StructField, name string) bool {
+	for _, tag := range []string{"json", "yaml", "toml", "xml"} {
+		tagName := strings.Split(field.Tag.Get(tag), ",")[0]
+		if tagName != "" && tagName == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func assignValue(target, rv reflect.Value) error {
+	for rv.Kind() == reflect.Interface && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if !rv.IsValid() {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	if rv.Type().AssignableTo(target.Type()) {
+		target.Set(rv)
+		return nil
+	}
+
+	if env.IsText(target.Type()) && rv.Kind() == reflect.String {
+		return env.Parse(target, rv.String())
+	}
+
+	if kindGroup(rv.Kind()) != 0 && kindGroup(rv.Kind()) == kindGroup(target.Kind()) {
+		converted := rv.Convert(target.Type())
+		if kindGroup(rv.Kind()) == 1 && !lossless(rv, converted) {
+			return errors.Errorf("cannot assign %v to %s", rv.Interface(), target.Type())
+		}
+		target.Set(converted)
+		return nil
+	}
+
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		return assignValue(target, rv.Elem())
+	}
+
+	return errors.New("cannot assign " + rv.Type().String() + " to " + target.Type().String())
+}
+
+// lossless checks if a number is unchanged by a conversion. Floats can be
+// converted to other float types with rounding.
+func lossless(rv, converted reflect.Value) bool {
+	if isFloat(rv.Kind()) && isFloat(converted.Kind()) {
+		return true
+	}
+
+	return isNegative(rv) == isNegative(converted) &&
+		converted.Convert(rv.Type()).Interface() == rv.Interface()
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNegative(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() < 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() < 0
+	}
+
+	return false
+}
+
+func kindGroup(k reflect.Kind) int {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return 1
+	case reflect.String:
+		return 2
+	case reflect.Bool:
+		return 3
+	}
+
+	return 0
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+)
+
+type databaseTest struct {
+	Host string `json:"hostname"`
+	Pool struct {
+		Max     int64
+		Timeout string
+		Idle    time.Duration
+	}
+	Replicas []string
+}
+
+func TestAccessors(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("database", reflect.TypeOf(databaseTest{}))
+	c.RegisterSchema("test.*", reflect.TypeOf(test{}))
+
+	db := databaseTest{Host: "localhost", Replicas: []string{"a", "b"}}
+	db.Pool.Max = 20
+	db.Pool.Timeout = "1m"
+	db.Pool.Idle = 30 * time.Second
+
+	mp := config.NewMemoryConfigProvider()
+	require.NoError(t, mp.Save("database", db))
+	require.NoError(t, mp.Save("test.0", testExample()))
+	collection := config.NewCollection()
+	collection.AddProviders(mp)
+	c.AddCollection("config", collection)
+	conf := c.Get("config")
+
+	t.Run("whole value", func(t *testing.T) {
+		var v databaseTest
+		require.NoError(t, conf.GetInto("database", &v))
+		require.Equal(t, db, v)
+		require.Equal(t, testExample(), config.MustGet(conf, "test.0"))
+	})
+
+	t.Run("sub-paths", func(t *testing.T) {
+		i, err := config.GetInt(conf, "database.pool.max")
+		require.NoError(t, err)
+		require.Equal(t, 20, i)
+
+		s, err := config.GetString(conf, "database.hostname")
+		require.NoError(t, err)
+		require.Equal(t, "localhost", s)
+
+		s, err = config.GetString(conf, "database.replicas.1")
+		require.NoError(t, err)
+		require.Equal(t, "b", s)
+
+		d, err := config.GetDuration(conf, "database.pool.timeout")
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, d)
+
+		d, err = config.GetDuration(conf, "database.pool.idle")
+		require.NoError(t, err)
+		require.Equal(t, 30*time.Second, d)
+
+		f := 0.0
+		require.NoError(t, conf.GetInto("test.0.d.f", &f))
+		require.Equal(t, -1.2, f)
+
+		require.NoError(t, conf.GetInto("test.0.d.e", &f))
+		require.Equal(t, -2.0, f)
+
+		var i8 int8
+		require.NoError(t, conf.GetInto("database.pool.max", &i8))
+		require.Equal(t, int8(20), i8)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := config.GetInt(conf, "database.hostname")
+		require.Error(t, err)
+
+		_, err = config.GetString(conf, "database.pool.missing")
+		require.IsType(t, &config.KeyNotFoundError{}, err)
+
+		_, err = config.GetString(conf, "test.1.b")
+		require.IsType(t, &config.KeyNotFoundError{}, err)
+
+		_, err = config.GetInt(conf, "test.0.d.f")
+		require.Error(t, err)
+
+		var u uint
+		require.Error(t, conf.GetInto("test.0.d.e", &u))
+
+		require.Panics(t, func() {
+			config.MustGet(conf, "unknown")
+		})
+	})
+}
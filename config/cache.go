@@ -0,0 +1,104 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheTTLProvider is implemented by providers whose values must be reloaded
+// periodically, because they can change without a notification.
+//
+// The cached values of a collection, including the missing keys, expire
+// after the shortest TTL of its providers. A zero TTL means no expiry, so the
+// TTL of a value that is already stale is a small positive duration.
+type CacheTTLProvider interface {
+	CacheTTL(key string) time.Duration
+}
+
+// expiredCacheTTL is the TTL of the values that must be reloaded on the next
+// use.
+const expiredCacheTTL = time.Nanosecond
+
+// cacheEntry is a cached value. The value of a missing key is nil.
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func (e cacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// cacheTTL returns the shortest TTL of the providers of a key.
+func (c *Collection) cacheTTL(key string) time.Duration {
+	var ttl time.Duration
+	for _, provider := range c.getProviders() {
+		if tp, ok := provider.(CacheTTLProvider); ok {
+			if t := tp.CacheTTL(key); t > 0 && (ttl == 0 || t < ttl) {
+				ttl = t
+			}
+		}
+	}
+
+	return ttl
+}
+
+// flightGroup runs only one load of a key at a time. The concurrent callers
+// wait for the running load and share its result.
+type flightGroup struct {
+	mtx   sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mtx.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	if f, found := g.calls[key]; found {
+		g.mtx.Unlock()
+		f.wg.Wait()
+		return f.val, f.err
+	}
+
+	f := &flight{}
+	f.wg.Add(1)
+	g.calls[key] = f
+	g.mtx.Unlock()
+
+	defer func() {
+		g.mtx.Lock()
+		delete(g.calls, key)
+		g.mtx.Unlock()
+		f.wg.Done()
+	}()
+
+	f.val, f.err = fn()
+
+	return f.val, f.err
+}
@@ -20,8 +20,10 @@
 package config
 
 import (
+	"context"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/imdario/mergo"
 	"github.com/pkg/errors"
@@ -37,8 +39,27 @@ type ConfigSchemaProvider interface {
 	ConfigSchema() map[string]reflect.Type
 }
 
+// ConfigDefaultsProvider supplies default values for registered schemas.
+//
+// The values must have the same type as the schema.
+type ConfigDefaultsProvider interface {
+	ConfigDefaults() map[string]interface{}
+}
+
+// RequiredConfigProvider lists the schemas that must be present in at least
+// one provider.
+type RequiredConfigProvider interface {
+	RequiredConfig() []string
+}
+
 type Config interface {
 	Get(key string) (interface{}, error)
+	// GetInto stores the value of a key or a sub-path of a key (e.g.
+	// "database.pool.max") in the value pointed to by v.
+	GetInto(key string, v interface{}) error
+	// Keys lists the keys matching a pattern (e.g. "test.*" or "test.**", see
+	// matcher.Matcher) in the providers that can enumerate their keys.
+	Keys(pattern string) ([]string, error)
 }
 
 type WritableConfig interface {
@@ -51,22 +72,69 @@ type Provider interface {
 	Unmarshal(key string, v interface{}) error
 }
 
+// overridingProvider is implemented by providers whose set fields override
+// the other layers, even with zero values. Their Unmarshal only sets the set
+// fields.
+type overridingProvider interface {
+	Provider
+	isSet(key, path string) bool
+}
+
+// KeyLister is implemented by providers that can enumerate their keys.
+type KeyLister interface {
+	Keys() ([]string, error)
+}
+
+// SchemaProvider is implemented by providers whose keys depend on the type of
+// the value, like EnvConfigProvider with absolute variable names. It is used
+// instead of Has when the type of the key is known.
+type SchemaProvider interface {
+	HasSchema(key string, t reflect.Type) bool
+}
+
+func providerHas(provider Provider, key string, t reflect.Type) bool {
+	if sp, ok := provider.(SchemaProvider); ok && t != nil {
+		return sp.HasSchema(key, t)
+	}
+
+	return provider.Has(key)
+}
+
 type WritableProvider interface {
 	Provider
 	CanSave(key string) bool
 	Save(key string, v interface{}) error
 }
 
+// Saver saves a value read by WritableConfig.GetWritable.
+//
+// If the provider tracks revisions, Save fails with a ConflictError when the
+// stored value was modified since it was read.
 type Saver interface {
 	Save(v interface{}) error
+	// Revision returns the revision of the stored value at the time it was
+	// read. It is empty if the value did not exist, or the provider does not
+	// track revisions.
+	Revision() string
+}
+
+var _ ContextSaver = &saver{}
+
+type saver struct {
+	revision string
+	save     func(ctx context.Context, v interface{}, revision string) error
 }
 
-var _ Saver = saverFunc(nil)
+func (s *saver) Save(v interface{}) error {
+	return s.save(context.Background(), v, s.revision)
+}
 
-type saverFunc func(v interface{}) error
+func (s *saver) SaveContext(ctx context.Context, v interface{}) error {
+	return s.save(ctx, v, s.revision)
+}
 
-func (f saverFunc) Save(v interface{}) error {
-	return f(v)
+func (s *saver) Revision() string {
+	return s.revision
 }
 
 type CollectionLoader interface {
@@ -84,16 +152,24 @@ func (f CollectionLoaderFunc) Load(name string) (*Collection, error) {
 type Store struct {
 	mtx               sync.RWMutex
 	namespaces        map[string]*Collection
-	schemas           *matcher.Matcher
+	schemas           *matcher.AtomicMatcher
+	schemaIndex       map[string]*schema
 	collectionLoaders []CollectionLoader
+	overrides         []Provider
 	logger            logger.Logger
+	watchMtx          sync.Mutex
+	watchers          map[string]map[string][]*watcher
+	secrets           *secretResolvers
 }
 
 func NewStore(logger logger.Logger) *Store {
 	return &Store{
-		namespaces: make(map[string]*Collection),
-		schemas:    matcher.NewMatcher("."),
-		logger:     logger,
+		namespaces:  make(map[string]*Collection),
+		schemas:     matcher.NewAtomicMatcher("."),
+		schemaIndex: make(map[string]*schema),
+		logger:      logger,
+		watchers:    make(map[string]map[string][]*watcher),
+		secrets:     newSecretResolvers(),
 	}
 }
 
@@ -101,6 +177,24 @@ func (s *Store) AddCollection(namespace string, collection *Collection) {
 	s.mtx.Lock()
 	s.namespaces[namespace] = collection
 	s.mtx.Unlock()
+	s.attach(namespace, collection)
+}
+
+// AddOverrides adds providers that take precedence over the providers of
+// every collection, including the collections loaded later by the collection
+// loaders. The providers added later take precedence.
+func (s *Store) AddOverrides(providers ...Provider) {
+	s.mtx.Lock()
+	s.overrides = append(s.overrides, providers...)
+	collections := make([]*Collection, 0, len(s.namespaces))
+	for _, collection := range s.namespaces {
+		collections = append(collections, collection)
+	}
+	s.mtx.Unlock()
+
+	for _, collection := range collections {
+		collection.prependOverrides(providers)
+	}
 }
 
 func (s *Store) AddCollectionLoaders(cl ...CollectionLoader) {
@@ -113,17 +207,94 @@ func (s *Store) MaybeRegisterSchema(v interface{}) {
 			s.RegisterSchema(name, t)
 		}
 	}
+	if cdp, ok := v.(ConfigDefaultsProvider); ok {
+		for name, d := range cdp.ConfigDefaults() {
+			s.RegisterDefaults(name, d)
+		}
+	}
+	if rcp, ok := v.(RequiredConfigProvider); ok {
+		for _, name := range rcp.RequiredConfig() {
+			s.RegisterRequired(name)
+		}
+	}
 }
 
-func (s *Store) RegisterSchema(name string, schema reflect.Type) {
+func (s *Store) RegisterSchema(name string, schemaType reflect.Type) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	if s.schemas.Get(name) != nil && s.schemas.Get(name) != schema {
+	if existing, found := s.schemaIndex[name]; found {
+		if existing.typ != schemaType {
+			panic("schema " + name + " is already registered")
+		}
+		return
+	}
+
+	if existing := s.schemas.Get(name); existing != nil && existing.(*schema).typ != schemaType {
 		panic("schema " + name + " is already registered")
 	}
 
-	s.schemas.Set(name, schema)
+	sc := newSchema(schemaType)
+	s.schemaIndex[name] = sc
+	s.schemas.Set(name, sc)
+}
+
+// Schemas returns the types of the registered schemas by their patterns.
+func (s *Store) Schemas() map[string]reflect.Type {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	schemas := make(map[string]reflect.Type, len(s.schemaIndex))
+	for name, sc := range s.schemaIndex {
+		schemas[name] = sc.typ
+	}
+
+	return schemas
+}
+
+// RegisterDefaults sets the default value of a registered schema.
+//
+// The default value is the lowest precedence layer when merging the values
+// of the providers. Non-zero fields of v override the `default` struct tags.
+func (s *Store) RegisterDefaults(name string, v interface{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	sc, found := s.schemaIndex[name]
+	if !found {
+		panic("schema " + name + " is not registered")
+	}
+
+	updated := *sc
+	if err := updated.setDefaults(v); err != nil {
+		panic("invalid defaults for schema " + name + ": " + err.Error())
+	}
+	s.replaceSchema(name, &updated)
+}
+
+// RegisterRequired marks a registered schema as required.
+//
+// Getting a required key that is missing from all providers returns a
+// KeyNotFoundError.
+func (s *Store) RegisterRequired(name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	sc, found := s.schemaIndex[name]
+	if !found {
+		panic("schema " + name + " is not registered")
+	}
+
+	updated := *sc
+	updated.required = true
+	s.replaceSchema(name, &updated)
+}
+
+// replaceSchema swaps a registered schema with a modified copy, since the
+// schemas are read without locking.
+func (s *Store) replaceSchema(name string, sc *schema) {
+	s.schemaIndex[name] = sc
+	s.schemas.Set(name, sc)
 }
 
 func (s *Store) ClearAllCaches() {
@@ -156,6 +327,7 @@ func (s *Store) ensureNamespace(namespace string) *Collection {
 			s.mtx.Lock()
 			s.namespaces[namespace] = collection
 			s.mtx.Unlock()
+			s.attach(namespace, collection)
 			return collection
 		}
 	}
@@ -198,44 +370,43 @@ func (s *Store) get(namespace, key string) (interface{}, error) {
 		return nil, CollectionNotFoundError{namespace}
 	}
 
-	var val interface{}
-	var err error
-
-	s.mtx.RLock()
-	if returnType := s.schemas.Get(key); returnType != nil {
-		val, err = collection.get(key, returnType.(reflect.Type))
-	} else {
-		err = errors.New("schema not found")
+	sc := s.schemas.Get(key)
+	if sc == nil {
+		return nil, withNamespace(errors.New("schema not found"), namespace)
 	}
-	s.mtx.RUnlock()
 
-	return val, err
+	val, err := collection.get(key, sc.(*schema))
+
+	return val, withNamespace(err, namespace)
 }
 
-func (s *Store) set(namespace, key string, v interface{}) error {
+func (s *Store) set(ctx context.Context, namespace, key string, v interface{}, revision string, refs map[string]secretRef) error {
 	collection := s.ensureNamespace(namespace)
 	if collection == nil {
 		return CollectionNotFoundError{namespace}
 	}
 
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
-	if returnType := s.schemas.Get(key); returnType != nil {
-		if reflect.TypeOf(v) != returnType.(reflect.Type) {
-			return errors.New("invalid type")
-		}
-	} else {
+	sc := s.schemas.Get(key)
+	if sc == nil {
 		return errors.New("unknown type")
 	}
+	if reflect.TypeOf(v) != sc.(*schema).typ {
+		return errors.New("invalid type")
+	}
 
-	return collection.set(key, v)
+	return withNamespace(collection.set(ctx, key, v, revision, refs), namespace)
 }
 
 type Collection struct {
-	mtx       sync.RWMutex
-	cache     map[string]interface{}
-	providers []Provider
-	temporary bool
+	mtx        sync.RWMutex
+	saveMtx    sync.Mutex
+	cache      map[string]cacheEntry
+	generation uint64
+	flights    flightGroup
+	providers  []Provider
+	temporary  bool
+	onChange   func(key string)
+	secrets    *secretResolvers
 }
 
 func NewCollection() *Collection {
@@ -244,92 +415,238 @@ func NewCollection() *Collection {
 	return c
 }
 
-func (c *Collection) get(key string, returnType reflect.Type) (interface{}, error) {
+// get returns the value of a key from the cache, or loads it from the
+// providers. Missing keys are cached as well, including the required ones.
+// Concurrent loads of the same key are merged.
+func (c *Collection) get(key string, sc *schema) (interface{}, error) {
 	val, found := c.getFromCache(key)
-	if found {
-		return val, nil
+	if !found {
+		var err error
+		val, err = c.flights.do(key, func() (interface{}, error) {
+			generation := c.cacheGeneration()
+			val, _, err := c.find(key, sc)
+			// a missing required key is cached as nil
+			if nf, ok := err.(*KeyNotFoundError); ok && nf.Key == key && sc.required {
+				err = nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			c.putLoadedToCache(key, val, generation)
+
+			return val, nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	val, err := c.find(key, returnType)
+	if val == nil && sc.required {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+
+	return val, nil
+}
 
+// find loads a value from the providers. The secret references of the value
+// are returned as well, so they can be restored before saving it.
+func (c *Collection) find(key string, sc *schema) (interface{}, map[string]secretRef, error) {
+	res, err := c.resolve(key, sc)
+	if err != nil || res == nil {
+		return nil, nil, err
+	}
+
+	if err = validateValue(key, res.value, res.layers); err != nil {
+		return nil, nil, err
+	}
+
+	return reflect.Indirect(res.value).Interface(), res.secrets, nil
+}
+
+type resolution struct {
+	value   reflect.Value
+	layers  []layer
+	secrets map[string]secretRef
+}
+
+// resolve loads and merges the layers of a key, and resolves the secret
+// references in the result.
+//
+// Returns nil if the key is not found, and it has no defaults.
+func (c *Collection) resolve(key string, sc *schema) (*resolution, error) {
+	layers, err := c.load(key, sc.typ)
 	if err != nil {
 		return nil, err
 	}
 
-	c.putToCache(key, val)
+	if len(layers) == 0 {
+		if sc.required {
+			return nil, &KeyNotFoundError{Key: key}
+		}
+		if !sc.defaults.IsValid() {
+			return nil, nil
+		}
+	}
 
-	return val, nil
+	if sc.defaults.IsValid() {
+		// the merge shares the maps, slices and pointers of the layers
+		defaults := reflect.New(sc.typ)
+		defaults.Elem().Set(deepCopy(sc.defaults.Elem()))
+		layers = append(layers, layer{
+			source: "default",
+			value:  defaults,
+		})
+	}
+
+	ptr, err := mergeLayers(sc.typ, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	// zero values are skipped by the merge
+	for i := len(layers) - 1; i >= 0; i-- {
+		if op, ok := layers[i].provider.(overridingProvider); ok {
+			if err = op.Unmarshal(key, ptr.Interface()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	secrets, err := c.resolveSecrets(ptr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve secrets of "+key)
+	}
+
+	return &resolution{
+		value:   ptr,
+		layers:  layers,
+		secrets: secrets,
+	}, nil
 }
 
-func (c *Collection) find(key string, returnType reflect.Type) (interface{}, error) {
-	var ptr reflect.Value
-	merge := false
+func (c *Collection) load(key string, returnType reflect.Type) ([]layer, error) {
+	var layers []layer
 
-	for _, provider := range c.providers {
-		if provider.Has(key) {
+	for _, provider := range c.getProviders() {
+		if providerHas(provider, key, returnType) {
 			currentPtr := reflect.New(returnType)
 			if err := provider.Unmarshal(key, currentPtr.Interface()); err != nil {
 				return nil, err
 			}
-			if !merge {
-				ptr = currentPtr
-				merge = true
-			} else if err := mergo.Merge(ptr.Interface(), reflect.Indirect(currentPtr).Interface()); err != nil {
-				return nil, err
-			}
+			layers = append(layers, layer{
+				provider: provider,
+				source:   describeProvider(provider, key),
+				value:    currentPtr,
+			})
 		}
 	}
 
-	if ptr.IsValid() {
-		return reflect.Indirect(ptr).Interface(), nil
-	}
-
-	return nil, nil
+	return layers, nil
 }
 
-func (c *Collection) set(key string, v interface{}) error {
-	var err error
-	var saved bool
-	c.mtx.Lock()
-	for _, provider := range c.providers {
-		if wp, ok := provider.(WritableProvider); ok && wp.CanSave(key) {
-			err = wp.Save(key, v)
-			saved = true
-			break
+func mergeLayers(returnType reflect.Type, layers []layer) (reflect.Value, error) {
+	ptr := reflect.New(returnType)
+	ptr.Elem().Set(layers[0].value.Elem())
+
+	for _, l := range layers[1:] {
+		if err := mergo.Merge(ptr.Interface(), l.value.Elem().Interface()); err != nil {
+			return reflect.Value{}, err
 		}
 	}
-	c.mtx.Unlock()
 
-	if err != nil {
+	return ptr, nil
+}
+
+// set saves a value. The unchanged resolved secrets of the value are replaced
+// with their references first.
+func (c *Collection) set(ctx context.Context, key string, v interface{}, revision string, refs map[string]secretRef) error {
+	if err := validateValue(key, reflect.ValueOf(v), nil); err != nil {
 		return err
 	}
 
-	if !saved {
+	stored := restoreSecretRefs(refs, v)
+
+	wp := c.writableProvider(key)
+	if wp == nil {
 		return errors.New("failed to save config")
 	}
 
+	var err error
+	c.saveMtx.Lock()
+	if cp, ok := wp.(ContextRevisionedProvider); ok {
+		err = cp.SaveRevisionContext(ctx, key, stored, revision)
+	} else if rp, ok := wp.(RevisionedProvider); ok {
+		err = rp.SaveRevision(key, stored, revision)
+	} else {
+		err = wp.Save(key, stored)
+	}
+	c.saveMtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
 	c.putToCache(key, v)
+	c.notify(key)
 
 	return nil
 }
 
 func (c *Collection) getFromCache(key string) (interface{}, bool) {
 	c.mtx.RLock()
-	val, exists := c.cache[key]
+	entry, exists := c.cache[key]
 	c.mtx.RUnlock()
 
-	return val, exists
+	if !exists || entry.expired(time.Now()) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+func (c *Collection) cacheGeneration() uint64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.generation
 }
 
 func (c *Collection) putToCache(key string, v interface{}) {
+	entry := c.newCacheEntry(key, v)
+
+	c.mtx.Lock()
+	c.cache[key] = entry
+	c.mtx.Unlock()
+}
+
+// putLoadedToCache caches a loaded value, unless the cache was invalidated
+// since the load started.
+func (c *Collection) putLoadedToCache(key string, v interface{}, generation uint64) {
+	entry := c.newCacheEntry(key, v)
+
 	c.mtx.Lock()
-	c.cache[key] = v
+	if c.generation == generation {
+		c.cache[key] = entry
+	}
 	c.mtx.Unlock()
 }
 
+func (c *Collection) newCacheEntry(key string, v interface{}) cacheEntry {
+	entry := cacheEntry{
+		value: v,
+	}
+	if ttl := c.cacheTTL(key); ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	return entry
+}
+
 func (c *Collection) ClearCache() {
 	c.mtx.Lock()
-	c.cache = make(map[string]interface{})
+	c.cache = make(map[string]cacheEntry)
+	c.generation++
 	c.mtx.Unlock()
 }
 
@@ -337,8 +654,68 @@ func (c *Collection) SetTemporary(temporary bool) {
 	c.temporary = temporary
 }
 
+// PrependProviders adds providers that take precedence over the current
+// providers of the collection. Providers that are already added are skipped.
+func (c *Collection) PrependProviders(providers ...Provider) {
+	c.mtx.Lock()
+	var added []Provider
+	for _, provider := range providers {
+		if !c.hasProvider(provider) {
+			added = append(added, provider)
+		}
+	}
+	if len(added) > 0 {
+		c.providers = append(added, c.providers...)
+	}
+	c.mtx.Unlock()
+
+	if len(added) > 0 {
+		c.onAdd(added)
+	}
+}
+
+// prependOverrides prepends the overrides of a store one by one, so the last
+// one takes precedence.
+func (c *Collection) prependOverrides(overrides []Provider) {
+	for _, provider := range overrides {
+		c.PrependProviders(provider)
+	}
+}
+
+// hasProvider must be called with c.mtx held.
+func (c *Collection) hasProvider(provider Provider) bool {
+	for _, p := range c.providers {
+		if p == provider {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Collection) AddProviders(providers ...Provider) {
-	c.providers = append(c.providers, providers...)
+	c.mtx.Lock()
+	c.providers = append(c.providers[:len(c.providers):len(c.providers)], providers...)
+	c.mtx.Unlock()
+
+	c.onAdd(providers)
+}
+
+// getProviders returns the providers of the collection. The slice is never
+// modified in place, so it can be iterated without holding c.mtx.
+func (c *Collection) getProviders() []Provider {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.providers
+}
+
+func (c *Collection) onAdd(providers []Provider) {
+	for _, provider := range providers {
+		if n, ok := provider.(ChangeNotifier); ok {
+			n.OnChange(c.Invalidate)
+		}
+	}
 	c.ClearCache()
 }
 
@@ -352,19 +729,30 @@ func (i *instance) Get(key string) (interface{}, error) {
 	return i.parent.get(i.namespace, key)
 }
 
+func (i *instance) GetInto(key string, v interface{}) error {
+	return i.parent.getInto(i.namespace, key, v)
+}
+
+func (i *instance) Keys(pattern string) ([]string, error) {
+	return i.parent.keys(i.namespace, pattern)
+}
+
 func (i *instance) GetWritable(key string) (interface{}, Saver, error) {
 	if i.readonly {
 		return nil, nil, errors.New("readonly instance cannot be used as writable")
 	}
 
-	val, err := i.Get(key)
+	val, revision, refs, err := i.parent.getWritable(i.namespace, key)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return val, saverFunc(func(v interface{}) error {
-		return i.parent.set(i.namespace, key, v)
-	}), nil
+	return val, &saver{
+		revision: revision,
+		save: func(ctx context.Context, v interface{}, revision string) error {
+			return i.parent.set(ctx, i.namespace, key, v, revision, refs)
+		},
+	}, nil
 }
 
 var _ error = CollectionNotFoundError{}
@@ -376,3 +764,20 @@ type CollectionNotFoundError struct {
 func (e CollectionNotFoundError) Error() string {
 	return "collection not found: " + e.Name
 }
+
+var _ error = &KeyNotFoundError{}
+
+// KeyNotFoundError is returned when a required key is missing from all
+// providers.
+type KeyNotFoundError struct {
+	Namespace string
+	Key       string
+}
+
+func (e *KeyNotFoundError) Error() string {
+	if e.Namespace == "" {
+		return "config key not found: " + e.Key
+	}
+
+	return "config key not found: " + e.Namespace + "/" + e.Key
+}
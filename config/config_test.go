@@ -20,13 +20,16 @@
 package config_test
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
@@ -41,10 +44,15 @@ var (
 	_ config.FileType         = &config.YAML{}
 	_ config.FileType         = &config.TOML{}
 	_ config.FileType         = &config.XML{}
+	_ config.FileType         = &config.HCL{}
+	_ config.FileType         = &config.INI{}
+	_ config.FileType         = &config.JSON5{}
+	_ config.FileType         = &config.DotEnv{}
 	_ config.CollectionLoader = &config.Directory{}
 	_ config.CollectionLoader = &config.Database{}
 	_ config.WritableProvider = &config.DatabaseConfigProvider{}
 	_ config.WritableProvider = &errorProvider{}
+	_ config.CacheTTLProvider = &countingProvider{}
 )
 
 type errorProvider struct {
@@ -93,7 +101,7 @@ func TestConfig(t *testing.T) {
 	c := config.NewStore(null.NewLogger())
 	c.RegisterSchema("test.*", reflect.TypeOf(test{}))
 
-	entries := []string{"test.0", "test.1", "test.2", "test.3"}
+	entries := []string{"test.0", "test.1", "test.2", "test.3", "test.4", "test.5", "test.6", "test.7"}
 	for _, entry := range entries {
 		_ = os.Setenv("CONFIG_"+strings.ToUpper(entry)+"_G", "zxcvbn")
 	}
@@ -124,6 +132,10 @@ func TestWritableConfig(t *testing.T) {
 		&config.YAML{},
 		&config.TOML{},
 		&config.XML{},
+		&config.HCL{},
+		&config.INI{},
+		&config.JSON5{},
+		&config.DotEnv{},
 	}
 
 	for _, entry := range entries {
@@ -315,6 +327,125 @@ func TestDatabaseCollectionLoader(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, testExample(), v)
 	})
+
+	t.Run("concurrent modifications are detected", func(t *testing.T) {
+		_, first, err := conf.GetWritable(ns0).GetWritable("test")
+		require.NoError(t, err)
+		require.Equal(t, "1", first.Revision())
+		_, second, err := conf.GetWritable(ns0).GetWritable("test")
+		require.NoError(t, err)
+
+		require.NoError(t, first.Save(testExample()))
+		err = second.Save(testExample())
+		require.IsType(t, &config.ConflictError{}, err)
+		require.Equal(t, "2", err.(*config.ConflictError).Actual)
+	})
+}
+
+func TestDatabaseChangeListener(t *testing.T) {
+	conf := config.NewStore(null.NewLogger())
+
+	dbUrl := os.Getenv("DATABASE_URL")
+	if dbUrl == "" {
+		t.Skip("no database provided")
+	}
+	conn, cleanup := database.TestConnect(dbUrl)
+	t.Cleanup(cleanup)
+
+	cl := config.NewDatabase(conn, false)
+	conf.RegisterSchema("test", reflect.TypeOf(test{}))
+	conf.AddCollectionLoaders(cl)
+
+	_, err := cl.Migrations().Migrations().UpgradeFrom(-1, null.NewLogger(), conn)
+	require.NoError(t, err)
+
+	ns := util.RandomHexString(12)
+	_, err = conn.Exec(`INSERT INTO namespace(namespace) VALUES($1)`, ns)
+	require.NoError(t, err)
+	_, err = conn.Exec(`INSERT INTO config(namespace, name, value) VALUES($1, $2, $3)`, ns, "test", util.JSONString(testExample()))
+	require.NoError(t, err)
+
+	changes := make(chan interface{}, 1)
+	cancelWatch, err := conf.Watch(ns, "test", func(old, new interface{}) {
+		changes <- new
+	})
+	require.NoError(t, err)
+	defer cancelWatch()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := config.NewDatabaseChangeListener(dbUrl, conf, null.NewLogger())
+	go func() { util.Must(listener.Listen(ctx)) }()
+	time.Sleep(500 * time.Millisecond)
+
+	changed := testExample()
+	changed.B = "qwer"
+	_, err = conn.Exec(`UPDATE config SET value = $3 WHERE namespace = $1 AND name = $2`, ns, "test", util.JSONString(changed))
+	require.NoError(t, err)
+
+	select {
+	case v := <-changes:
+		require.Equal(t, changed, v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("change notification was not received")
+	}
+}
+
+func TestDatabaseHistory(t *testing.T) {
+	conf := config.NewStore(null.NewLogger())
+
+	dbUrl := os.Getenv("DATABASE_URL")
+	if dbUrl == "" {
+		t.Skip("no database provided")
+	}
+	conn, cleanup := database.TestConnect(dbUrl)
+	t.Cleanup(cleanup)
+
+	cl := config.NewDatabase(conn, false)
+	conf.RegisterSchema("test", reflect.TypeOf(test{}))
+	conf.AddCollectionLoaders(cl)
+
+	_, err := cl.Migrations().Migrations().UpgradeFrom(-1, null.NewLogger(), conn)
+	require.NoError(t, err)
+
+	ns := util.RandomHexString(12)
+	_, err = conn.Exec(`INSERT INTO namespace(namespace) VALUES($1)`, ns)
+	require.NoError(t, err)
+
+	ctx := config.WithReason(config.WithActor(context.Background(), "admin"), "initial")
+	_, saver, err := conf.GetWritable(ns).GetWritable("test")
+	require.NoError(t, err)
+	require.NoError(t, saver.(config.ContextSaver).SaveContext(ctx, testExample()))
+
+	changed := testExample()
+	changed.B = "qwer"
+	_, saver, err = conf.GetWritable(ns).GetWritable("test")
+	require.NoError(t, err)
+	require.NoError(t, saver.Save(changed))
+
+	history, err := cl.History(ns, "test")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, int64(1), history[0].Revision)
+	require.Nil(t, history[0].OldValue)
+	require.Equal(t, "admin", history[0].Actor)
+	require.Equal(t, "initial", history[0].Reason)
+	require.Equal(t, int64(2), history[1].Revision)
+	require.JSONEq(t, string(history[0].NewValue), string(history[1].OldValue))
+	require.Empty(t, history[1].Actor)
+
+	require.NoError(t, cl.Rollback(config.WithActor(context.Background(), "oncall"), ns, "test", 1))
+
+	v, err := conf.Get(ns).Get("test")
+	require.NoError(t, err)
+	require.Equal(t, testExample(), v)
+
+	history, err = cl.History(ns, "test")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, int64(3), history[2].Revision)
+	require.Equal(t, "oncall", history[2].Actor)
+	require.Equal(t, "rollback to revision 1", history[2].Reason)
 }
 
 func testExample() test {
@@ -355,4 +486,179 @@ func registerFileTypes(dp *config.DirectoryConfigProvider) {
 	dp.RegisterFiletype(&config.JSON{})
 	dp.RegisterFiletype(&config.TOML{})
 	dp.RegisterFiletype(&config.XML{})
+	dp.RegisterFiletype(&config.HCL{})
+	dp.RegisterFiletype(&config.INI{})
+	dp.RegisterFiletype(&config.JSON5{})
+	dp.RegisterFiletype(&config.DotEnv{})
+}
+
+func TestEnvConfigProviderTags(t *testing.T) {
+	type tagged struct {
+		Host     string `env:"TAGS_TEST_PGHOST,absolute"`
+		MaxConns int    `env:"MAX_CONNS" envDefault:"10"`
+		Timeout  int
+	}
+
+	require.NoError(t, os.Setenv("TAGS_TEST_PGHOST", "localhost"))
+	defer func() { _ = os.Unsetenv("TAGS_TEST_PGHOST") }()
+
+	ep := config.NewEnvConfigProvider()
+	ep.Prefix = "CONFIG"
+	ep.Reset()
+	mp := config.NewMemoryConfigProvider()
+	require.NoError(t, mp.Save("db", tagged{MaxConns: 5, Timeout: 30}))
+	collection := config.NewCollection()
+	collection.AddProviders(ep, mp)
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("db", reflect.TypeOf(tagged{}))
+	c.AddCollection("config", collection)
+
+	v, err := c.Get("config").Get("db")
+	require.NoError(t, err)
+	require.Equal(t, tagged{Host: "localhost", MaxConns: 5, Timeout: 30}, v)
+
+	require.NoError(t, mp.Save("db", tagged{}))
+	collection.ClearCache()
+	v, err = c.Get("config").Get("db")
+	require.NoError(t, err)
+	require.Equal(t, tagged{Host: "localhost", MaxConns: 10}, v)
+}
+
+// countingProvider wraps a provider, and counts the lookups.
+type countingProvider struct {
+	config.Provider
+	mtx   sync.Mutex
+	has   int
+	ttl   time.Duration
+	delay time.Duration
+}
+
+func (p *countingProvider) Has(key string) bool {
+	p.mtx.Lock()
+	p.has++
+	p.mtx.Unlock()
+	time.Sleep(p.delay)
+
+	return p.Provider.Has(key)
+}
+
+func (p *countingProvider) CacheTTL(key string) time.Duration {
+	return p.ttl
+}
+
+func (p *countingProvider) lookups() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.has
+}
+
+func TestCollectionCache(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test.*", reflect.TypeOf(test{}))
+
+	mp := config.NewMemoryConfigProvider()
+	cp := &countingProvider{Provider: mp}
+	collection := config.NewCollection()
+	collection.AddProviders(cp)
+	c.AddCollection("config", collection)
+	cfg := c.Get("config")
+
+	t.Run("missing keys are cached", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			v, err := cfg.Get("test.missing")
+			require.NoError(t, err)
+			require.Nil(t, v)
+		}
+		require.Equal(t, 1, cp.lookups())
+
+		require.NoError(t, mp.Save("test.missing", testExample()))
+		collection.Invalidate("test.missing")
+		v, err := cfg.Get("test.missing")
+		require.NoError(t, err)
+		require.Equal(t, testExample(), v)
+		require.Equal(t, 2, cp.lookups())
+	})
+
+	t.Run("entries expire after the ttl of the providers", func(t *testing.T) {
+		cp.ttl = 20 * time.Millisecond
+		collection.ClearCache()
+		require.NoError(t, mp.Save("test.ttl", testExample()))
+
+		_, err := cfg.Get("test.ttl")
+		require.NoError(t, err)
+		_, err = cfg.Get("test.ttl")
+		require.NoError(t, err)
+		require.Equal(t, 3, cp.lookups())
+
+		updated := testExample()
+		updated.A = 42
+		require.NoError(t, mp.Save("test.ttl", updated))
+		time.Sleep(2 * cp.ttl)
+
+		v, err := cfg.Get("test.ttl")
+		require.NoError(t, err)
+		require.Equal(t, updated, v)
+		require.Equal(t, 4, cp.lookups())
+	})
+
+	t.Run("concurrent misses are merged", func(t *testing.T) {
+		cp.ttl = 0
+		cp.delay = 20 * time.Millisecond
+		collection.ClearCache()
+		before := cp.lookups()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v, err := cfg.Get("test.missing")
+				require.NoError(t, err)
+				require.Equal(t, testExample(), v)
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, before+1, cp.lookups())
+	})
+
+	t.Run("concurrent misses get their own errors", func(t *testing.T) {
+		c.RegisterSchema("required", reflect.TypeOf(test{}))
+		c.RegisterRequired("required")
+
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = cfg.Get("required")
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			require.Equal(t, &config.KeyNotFoundError{Namespace: "config", Key: "required"}, err)
+		}
+	})
+
+	t.Run("missing required keys are cached", func(t *testing.T) {
+		cp.delay = 0
+		collection.ClearCache()
+		before := cp.lookups()
+
+		for i := 0; i < 3; i++ {
+			_, err := cfg.Get("required")
+			require.Equal(t, &config.KeyNotFoundError{Namespace: "config", Key: "required"}, err)
+		}
+		require.Equal(t, before+1, cp.lookups())
+
+		require.NoError(t, mp.Save("required", testExample()))
+		collection.Invalidate("required")
+		v, err := cfg.Get("required")
+		require.NoError(t, err)
+		require.Equal(t, testExample(), v)
+	})
 }
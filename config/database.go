@@ -20,32 +20,84 @@
 package config
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/tamasd/constellation/database"
 	"github.com/tamasd/constellation/logger"
+	"github.com/tamasd/constellation/util"
 )
 
+// DefaultDatabaseCacheTTL is the default time the values loaded from the
+// database are cached for, in case a change notification is missed.
+const DefaultDatabaseCacheTTL = time.Minute
+
 type Database struct {
 	conn     database.Connection
 	readOnly bool
+	keyRing  KeyRing
+	cacheTTL time.Duration
+	mtx      sync.Mutex
+	// providers holds the providers of the loaded collections by namespace,
+	// so the changes made through the Database notify them.
+	providers map[string]*DatabaseConfigProvider
 }
 
 func NewDatabase(conn database.Connection, readOnly bool) *Database {
 	return &Database{
-		conn:     conn,
-		readOnly: readOnly,
+		conn:      conn,
+		readOnly:  readOnly,
+		cacheTTL:  DefaultDatabaseCacheTTL,
+		providers: make(map[string]*DatabaseConfigProvider),
 	}
 }
 
+// SetKeyRing sets the key ring of the encrypted fields in the loaded
+// collections.
+func (d *Database) SetKeyRing(kr KeyRing) {
+	d.keyRing = kr
+}
+
+// SetCacheTTL sets the cache TTL of the loaded collections. Zero disables
+// the expiry.
+func (d *Database) SetCacheTTL(ttl time.Duration) {
+	d.cacheTTL = ttl
+}
+
 func (d *Database) Load(name string) (*Collection, error) {
+	p := NewDatabaseConfigProvider(d.conn, name, d.readOnly)
+	p.SetKeyRing(d.keyRing)
+	p.SetCacheTTL(d.cacheTTL)
+
 	c := NewCollection()
 	c.SetTemporary(true)
-	c.AddProviders(NewDatabaseConfigProvider(d.conn, name, d.readOnly))
+	c.AddProviders(p)
+
+	d.mtx.Lock()
+	d.providers[name] = p
+	d.mtx.Unlock()
 
 	return c, nil
 }
 
+// provider returns the provider of the last loaded collection of a
+// namespace, or a new one if the namespace is not loaded.
+func (d *Database) provider(namespace string) *DatabaseConfigProvider {
+	d.mtx.Lock()
+	p := d.providers[namespace]
+	d.mtx.Unlock()
+
+	if p == nil {
+		p = NewDatabaseConfigProvider(d.conn, namespace, d.readOnly)
+	}
+
+	return p
+}
+
 func (d *Database) Name() string {
 	return "config-database"
 }
@@ -69,13 +121,70 @@ func (d *Database) Migrations() database.MigrationsProvider {
 			`)
 			return err
 		},
+		func(l logger.Logger, conn database.Connection) error {
+			_, err := conn.Exec(`
+				CREATE FUNCTION config_notify() RETURNS trigger AS $$
+				BEGIN
+					IF TG_OP = 'DELETE' THEN
+						PERFORM pg_notify('` + DatabaseChangeChannel + `', json_build_object('namespace', OLD.namespace, 'name', OLD.name)::text);
+						RETURN OLD;
+					END IF;
+					PERFORM pg_notify('` + DatabaseChangeChannel + `', json_build_object('namespace', NEW.namespace, 'name', NEW.name)::text);
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				CREATE TRIGGER config_notify
+					AFTER INSERT OR UPDATE OR DELETE ON config
+					FOR EACH ROW EXECUTE PROCEDURE config_notify();
+			`)
+			return err
+		},
+		func(l logger.Logger, conn database.Connection) error {
+			_, err := conn.Exec(`
+				ALTER TABLE config ADD COLUMN revision bigint NOT NULL DEFAULT 1;
+			`)
+			return err
+		},
+		func(l logger.Logger, conn database.Connection) error {
+			_, err := conn.Exec(`
+				CREATE TABLE config_history (
+					id bigserial NOT NULL,
+					namespace character varying NOT NULL,
+					name character varying NOT NULL,
+					revision bigint NOT NULL,
+					old_value jsonb,
+					new_value jsonb NOT NULL,
+					actor character varying NOT NULL DEFAULT '',
+					reason text NOT NULL DEFAULT '',
+					created timestamp with time zone NOT NULL DEFAULT now(),
+					CONSTRAINT config_history_pkey PRIMARY KEY (id)
+				);
+
+				CREATE INDEX config_history_key_idx ON config_history (namespace, name);
+			`)
+			return err
+		},
 	)
 }
 
+var _ ContextRevisionedProvider = &DatabaseConfigProvider{}
+var _ ChangeNotifier = &DatabaseConfigProvider{}
+var _ Poller = &DatabaseConfigProvider{}
+var _ Describer = &DatabaseConfigProvider{}
+var _ encryptingProvider = &DatabaseConfigProvider{}
+var _ KeyLister = &DatabaseConfigProvider{}
+var _ CacheTTLProvider = &DatabaseConfigProvider{}
+
 type DatabaseConfigProvider struct {
-	conn      database.Connection
-	namespace string
-	readOnly  bool
+	changeListeners
+	conn        database.Connection
+	namespace   string
+	readOnly    bool
+	keyRing     KeyRing
+	cacheTTL    time.Duration
+	snapshotMtx sync.Mutex
+	snapshot    map[string]string
 }
 
 func NewDatabaseConfigProvider(conn database.Connection, namespace string, readOnly bool) *DatabaseConfigProvider {
@@ -83,6 +192,7 @@ func NewDatabaseConfigProvider(conn database.Connection, namespace string, readO
 		conn:      conn,
 		namespace: namespace,
 		readOnly:  readOnly,
+		cacheTTL:  DefaultDatabaseCacheTTL,
 	}
 }
 
@@ -92,6 +202,29 @@ func (p *DatabaseConfigProvider) Has(key string) bool {
 	return err == nil && found
 }
 
+func (p *DatabaseConfigProvider) Keys() ([]string, error) {
+	rows, err := p.conn.Query(`SELECT name FROM config WHERE namespace = $1 ORDER BY name`, p.namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer util.MustClose(rows)
+
+	var keys []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		keys = append(keys, name)
+	}
+
+	return keys, rows.Err()
+}
+
+func (p *DatabaseConfigProvider) Describe(key string) string {
+	return "db:config/" + p.namespace + "/" + key
+}
+
 func (p *DatabaseConfigProvider) Unmarshal(key string, v interface{}) error {
 	var jv string
 	if err := p.conn.QueryRow(`SELECT value FROM config WHERE namespace = $1 AND name = $2`, p.namespace, key).Scan(&jv); err != nil {
@@ -102,7 +235,35 @@ func (p *DatabaseConfigProvider) Unmarshal(key string, v interface{}) error {
 		return nil
 	}
 
-	return json.Unmarshal([]byte(jv), v)
+	if err := json.Unmarshal([]byte(jv), v); err != nil {
+		return err
+	}
+
+	return decryptFields(p.keyRing, v)
+}
+
+// SetKeyRing sets the key ring of the encrypted fields.
+func (p *DatabaseConfigProvider) SetKeyRing(kr KeyRing) {
+	p.keyRing = kr
+}
+
+// SetCacheTTL sets the time the values are cached for by the collection.
+// Zero disables the expiry.
+func (p *DatabaseConfigProvider) SetCacheTTL(ttl time.Duration) {
+	p.cacheTTL = ttl
+}
+
+func (p *DatabaseConfigProvider) CacheTTL(key string) time.Duration {
+	return p.cacheTTL
+}
+
+func (p *DatabaseConfigProvider) reEncrypt(key string, t reflect.Type) error {
+	ptr := reflect.New(t)
+	if err := p.Unmarshal(key, ptr.Interface()); err != nil {
+		return err
+	}
+
+	return p.Save(key, ptr.Elem().Interface())
 }
 
 func (p *DatabaseConfigProvider) CanSave(key string) bool {
@@ -110,13 +271,166 @@ func (p *DatabaseConfigProvider) CanSave(key string) bool {
 }
 
 func (p *DatabaseConfigProvider) Save(key string, v interface{}) error {
-	jv, _ := json.Marshal(v)
-	_, err := p.conn.Exec(`
-		INSERT INTO config(namespace, name, value)
-			VALUES($1, $2, $3)
-			ON CONFLICT (config_pkey)
-			DO UPDATE SET value = $3
-	`, p.namespace, key, string(jv))
-
-	return err
+	return p.save(context.Background(), key, v, nil)
+}
+
+// Revision returns the value of the revision column of a key.
+func (p *DatabaseConfigProvider) Revision(key string) (string, error) {
+	var revision string
+	err := p.conn.QueryRow(`SELECT revision::text FROM config WHERE namespace = $1 AND name = $2`, p.namespace, key).Scan(&revision)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return revision, err
+}
+
+// SaveRevision saves a value if the revision column of the key still has the
+// given value. An empty revision means that the key must not exist yet.
+func (p *DatabaseConfigProvider) SaveRevision(key string, v interface{}, revision string) error {
+	return p.save(context.Background(), key, v, &revision)
+}
+
+// SaveRevisionContext works like SaveRevision, and records the actor and the
+// reason of the context in the history.
+func (p *DatabaseConfigProvider) SaveRevisionContext(ctx context.Context, key string, v interface{}, revision string) error {
+	return p.save(ctx, key, v, &revision)
+}
+
+func (p *DatabaseConfigProvider) save(ctx context.Context, key string, v interface{}, revision *string) error {
+	v, err := encryptFields(p.keyRing, v)
+	if err != nil {
+		return err
+	}
+
+	jv, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return p.saveJSON(ctx, key, string(jv), revision)
+}
+
+// saveJSON stores a JSON document and records the change in the history in
+// one transaction.
+func (p *DatabaseConfigProvider) saveJSON(ctx context.Context, key, jv string, revision *string) (err error) {
+	tx, err := database.MaybeBegin(p.conn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := database.MaybeRollback(tx); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	var oldValue sql.NullString
+	var current string
+	err = tx.QueryRow(`
+		SELECT value::text, revision::text
+			FROM config
+			WHERE namespace = $1 AND name = $2
+			FOR UPDATE
+	`, p.namespace, key).Scan(&oldValue, &current)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if revision != nil && *revision != current {
+		return &ConflictError{
+			Key:      key,
+			Expected: *revision,
+			Actual:   current,
+		}
+	}
+
+	var newRevision int64
+	if revision != nil && *revision == "" {
+		err = tx.QueryRow(`
+			INSERT INTO config(namespace, name, value)
+				VALUES($1, $2, $3)
+				ON CONFLICT ON CONSTRAINT config_pkey
+				DO NOTHING
+				RETURNING revision
+		`, p.namespace, key, jv).Scan(&newRevision)
+		if err == sql.ErrNoRows {
+			// inserted concurrently
+			return &ConflictError{
+				Key: key,
+			}
+		}
+	} else {
+		err = tx.QueryRow(`
+			INSERT INTO config(namespace, name, value)
+				VALUES($1, $2, $3)
+				ON CONFLICT ON CONSTRAINT config_pkey
+				DO UPDATE SET value = $3, revision = config.revision + 1
+				RETURNING revision
+		`, p.namespace, key, jv).Scan(&newRevision)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO config_history(namespace, name, revision, old_value, new_value, actor, reason)
+			VALUES($1, $2, $3, $4, $5, $6, $7)
+	`, p.namespace, key, newRevision, oldValue, jv, actorFromContext(ctx), reasonFromContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	if err = database.MaybeCommit(tx); err != nil {
+		return err
+	}
+
+	p.notify(key)
+
+	return nil
+}
+
+// Poll compares the stored values of the namespace with the ones seen at the
+// previous poll, and notifies the listeners about the changed keys.
+//
+// The first call only records the current state.
+func (p *DatabaseConfigProvider) Poll() error {
+	rows, err := p.conn.Query(`SELECT name, value::text FROM config WHERE namespace = $1`, p.namespace)
+	if err != nil {
+		return err
+	}
+	defer util.MustClose(rows)
+
+	current := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		current[name] = value
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	p.snapshotMtx.Lock()
+	previous := p.snapshot
+	p.snapshot = current
+	p.snapshotMtx.Unlock()
+
+	if previous == nil {
+		return nil
+	}
+
+	for name, value := range current {
+		if old, found := previous[name]; !found || old != value {
+			p.notify(name)
+		}
+	}
+	for name := range previous {
+		if _, found := current[name]; !found {
+			p.notify(name)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,106 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tamasd/constellation/logger"
+)
+
+// DatabaseChangeChannel is the notification channel where the trigger
+// installed by the Database migrations announces the changed config rows.
+const DatabaseChangeChannel = "config_changed"
+
+type databaseChange struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// DatabaseChangeListener evicts the changed keys from a Store when the
+// database announces a change with NOTIFY.
+type DatabaseChangeListener struct {
+	dbUrl                string
+	store                *Store
+	logger               logger.Logger
+	MinReconnectInterval time.Duration
+	MaxReconnectInterval time.Duration
+}
+
+func NewDatabaseChangeListener(dbUrl string, store *Store, logger logger.Logger) *DatabaseChangeListener {
+	return &DatabaseChangeListener{
+		dbUrl:                dbUrl,
+		store:                store,
+		logger:               logger,
+		MinReconnectInterval: time.Second,
+		MaxReconnectInterval: time.Minute,
+	}
+}
+
+// Listen processes the notifications until the context is canceled.
+func (l *DatabaseChangeListener) Listen(ctx context.Context) error {
+	listener := pq.NewListener(l.dbUrl, l.MinReconnectInterval, l.MaxReconnectInterval, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			l.logger.WithError(err).Warn("config change listener error")
+		}
+	})
+	defer func() {
+		if err := listener.Close(); err != nil {
+			l.logger.WithError(err).Warn("failed to close config change listener")
+		}
+	}()
+
+	if err := listener.Listen(DatabaseChangeChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			l.handle(n)
+		}
+	}
+}
+
+func (l *DatabaseChangeListener) handle(n *pq.Notification) {
+	// A nil notification is sent after the connection is re-established,
+	// which means that some notifications might have been lost.
+	if n == nil {
+		l.logger.Info("config change listener reconnected, clearing caches")
+		l.store.ClearAllCaches()
+		return
+	}
+
+	change := databaseChange{}
+	if err := json.Unmarshal([]byte(n.Extra), &change); err != nil {
+		l.logger.
+			WithError(err).
+			WithField("payload", n.Extra).
+			Warn("invalid config change notification")
+		return
+	}
+
+	l.store.Invalidate(change.Namespace, change.Name)
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/imdario/mergo"
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/config/env"
+)
+
+type schema struct {
+	typ      reflect.Type
+	defaults reflect.Value
+	required bool
+}
+
+func newSchema(t reflect.Type) *schema {
+	sc := &schema{
+		typ: t,
+	}
+
+	ptr := reflect.New(t)
+	found, err := applyDefaultTags(ptr.Elem(), make(map[reflect.Type]bool))
+	if err != nil {
+		panic("invalid default tag in " + t.String() + ": " + err.Error())
+	}
+	if found {
+		sc.defaults = ptr
+	}
+
+	return sc
+}
+
+func (sc *schema) setDefaults(v interface{}) error {
+	if reflect.TypeOf(v) != sc.typ {
+		return errors.New("invalid type")
+	}
+
+	ptr := reflect.New(sc.typ)
+	ptr.Elem().Set(reflect.ValueOf(v))
+	if sc.defaults.IsValid() {
+		if err := mergo.Merge(ptr.Interface(), sc.defaults.Elem().Interface()); err != nil {
+			return err
+		}
+	}
+
+	sc.defaults = ptr
+
+	return nil
+}
+
+// applyDefaultTags fills the fields of a struct from their `default` tags, or
+// their `envDefault` tags used by the env package.
+//
+// Returns true if at least one default tag is found. The nested pointers are
+// only allocated if they have default tags. The struct types in visiting are
+// not entered again, so self-referential types terminate.
+func applyDefaultTags(rv reflect.Value, visiting map[reflect.Type]bool) (bool, error) {
+	if rv.Kind() != reflect.Struct || visiting[rv.Type()] {
+		return false, nil
+	}
+	visiting[rv.Type()] = true
+	defer delete(visiting, rv.Type())
+
+	found := false
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		fv := rv.Field(i)
+		tag, ok := field.Tag.Lookup("default")
+		if !ok {
+			tag, ok = field.Tag.Lookup("envDefault")
+		}
+		if ok {
+			if err := parseString(fv, tag); err != nil {
+				return false, errors.Wrap(err, field.Name)
+			}
+			found = true
+			continue
+		}
+
+		switch fv.Kind() {
+		case reflect.Struct:
+			nested, err := applyDefaultTags(fv, visiting)
+			if err != nil {
+				return false, errors.Wrap(err, field.Name)
+			}
+			found = found || nested
+		case reflect.Ptr:
+			if fv.Type().Elem().Kind() != reflect.Struct {
+				continue
+			}
+			ptr := reflect.New(fv.Type().Elem())
+			nested, err := applyDefaultTags(ptr.Elem(), visiting)
+			if err != nil {
+				return false, errors.Wrap(err, field.Name)
+			}
+			if nested {
+				fv.Set(ptr)
+				found = true
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// parseString sets a value from its string representation, with env.Parse.
+//
+// Slices are comma separated lists.
+func parseString(rv reflect.Value, value string) error {
+	if rv.Kind() == reflect.Ptr {
+		ptr := reflect.New(rv.Type().Elem())
+		if err := parseString(ptr.Elem(), value); err != nil {
+			return err
+		}
+		rv.Set(ptr)
+		return nil
+	}
+
+	if rv.Kind() != reflect.Slice || env.IsText(rv.Type()) {
+		return env.Parse(rv, value)
+	}
+
+	var parts []string
+	if value != "" {
+		parts = strings.Split(value, ",")
+	}
+	slice := reflect.MakeSlice(rv.Type(), len(parts), len(parts))
+	for i, part := range parts {
+		if err := parseString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+			return err
+		}
+	}
+	rv.Set(slice)
+
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+)
+
+type poolConfig struct {
+	Host    string        `default:"localhost"`
+	Port    int           `default:"5432"`
+	Timeout time.Duration `default:"5s"`
+	Tags    []string      `default:"a,b"`
+	Limits  struct {
+		Max int `default:"10"`
+		Min int
+	}
+}
+
+type defaultNode struct {
+	Name string `default:"node"`
+	Next *defaultNode
+}
+
+type poolService struct{}
+
+func (poolService) ConfigSchema() map[string]reflect.Type {
+	return map[string]reflect.Type{
+		"pool":     reflect.TypeOf(poolConfig{}),
+		"required": reflect.TypeOf(test{}),
+	}
+}
+
+func (poolService) ConfigDefaults() map[string]interface{} {
+	return map[string]interface{}{
+		"pool": poolConfig{Host: "db"},
+	}
+}
+
+func (poolService) RequiredConfig() []string {
+	return []string{"required"}
+}
+
+func TestDefaults(t *testing.T) {
+	newStore := func() (*config.Store, *config.MemoryConfigProvider) {
+		c := config.NewStore(null.NewLogger())
+		mp := config.NewMemoryConfigProvider()
+		collection := config.NewCollection()
+		collection.AddProviders(mp)
+		c.AddCollection("config", collection)
+		return c, mp
+	}
+
+	t.Run("default tags are used when the key is missing", func(t *testing.T) {
+		c, _ := newStore()
+		c.RegisterSchema("pool", reflect.TypeOf(poolConfig{}))
+
+		v, err := c.Get("config").Get("pool")
+		require.NoError(t, err)
+		expected := poolConfig{
+			Host:    "localhost",
+			Port:    5432,
+			Timeout: 5 * time.Second,
+			Tags:    []string{"a", "b"},
+		}
+		expected.Limits.Max = 10
+		require.Equal(t, expected, v)
+	})
+
+	t.Run("defaults are the lowest precedence layer", func(t *testing.T) {
+		c, mp := newStore()
+		c.MaybeRegisterSchema(poolService{})
+		require.NoError(t, mp.Save("pool", poolConfig{Port: 6543}))
+
+		v, err := c.Get("config").Get("pool")
+		require.NoError(t, err)
+		pc := v.(poolConfig)
+		require.Equal(t, "db", pc.Host)
+		require.Equal(t, 6543, pc.Port)
+		require.Equal(t, 5*time.Second, pc.Timeout)
+		require.Equal(t, 10, pc.Limits.Max)
+	})
+
+	t.Run("missing required key returns an error", func(t *testing.T) {
+		c, mp := newStore()
+		c.MaybeRegisterSchema(poolService{})
+
+		_, err := c.Get("config").Get("required")
+		require.Error(t, err)
+		kerr, ok := err.(*config.KeyNotFoundError)
+		require.True(t, ok)
+		require.Equal(t, "config", kerr.Namespace)
+		require.Equal(t, "required", kerr.Key)
+
+		require.NoError(t, mp.Save("required", testExample()))
+		v, err := c.Get("config").Get("required")
+		require.NoError(t, err)
+		require.Equal(t, testExample(), v)
+	})
+
+	t.Run("returned values do not share the defaults", func(t *testing.T) {
+		c, _ := newStore()
+		c.RegisterSchema("pool", reflect.TypeOf(poolConfig{}))
+
+		v, err := c.Get("config").Get("pool")
+		require.NoError(t, err)
+		v.(poolConfig).Tags[0] = "changed"
+		c.Invalidate("config", "pool")
+
+		v, err = c.Get("config").Get("pool")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, v.(poolConfig).Tags)
+	})
+
+	t.Run("self-referential schemas", func(t *testing.T) {
+		c, _ := newStore()
+		c.RegisterSchema("node", reflect.TypeOf(defaultNode{}))
+
+		v, err := c.Get("config").Get("node")
+		require.NoError(t, err)
+		require.Equal(t, defaultNode{Name: "node"}, v)
+	})
+
+	t.Run("defaults cannot be registered for unknown schemas", func(t *testing.T) {
+		c, _ := newStore()
+		require.Panics(t, func() {
+			c.RegisterDefaults("pool", poolConfig{})
+		})
+		c.RegisterSchema("pool", reflect.TypeOf(poolConfig{}))
+		require.Panics(t, func() {
+			c.RegisterDefaults("pool", test{})
+		})
+	})
+}
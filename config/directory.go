@@ -20,10 +20,17 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/tamasd/constellation/util"
@@ -33,6 +40,8 @@ type Directory struct {
 	base     string
 	conf     map[string]string
 	readOnly bool
+	keyRing  KeyRing
+	profiles []string
 }
 
 func NewDirectory(base string, conf map[string]string, readOnly bool) *Directory {
@@ -43,6 +52,26 @@ func NewDirectory(base string, conf map[string]string, readOnly bool) *Directory
 	}
 }
 
+// SetKeyRing sets the key ring of the encrypted fields in the loaded
+// collections.
+func (d *Directory) SetKeyRing(kr KeyRing) {
+	d.keyRing = kr
+}
+
+// SetProfiles sets the active profiles, in increasing order of precedence.
+//
+// For every profile, the values are also loaded from "<dir>/<profile>/<key>.<ext>"
+// and "<key>.<profile>.<ext>", overriding the values of the previous profiles
+// and the base directory. The profile files are never written.
+func (d *Directory) SetProfiles(profiles ...string) {
+	d.profiles = nil
+	for _, profile := range profiles {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			d.profiles = append(d.profiles, profile)
+		}
+	}
+}
+
 func (d *Directory) Load(name string) (*Collection, error) {
 	if alias, found := d.conf[name]; found {
 		name = alias
@@ -64,18 +93,58 @@ func (d *Directory) Load(name string) (*Collection, error) {
 	e := NewEnvConfigProvider()
 	e.Prefix = "NS_" + strings.ToUpper(name)
 
-	p := NewDirectoryConfigProvider(dir, d.readOnly)
+	providers := []Provider{e}
+	for i := len(d.profiles) - 1; i >= 0; i-- {
+		profile := d.profiles[i]
+
+		pd := d.newProvider(filepath.Join(dir, profile), true)
+		ps := d.newProvider(dir, true)
+		ps.suffix = profile
+
+		providers = append(providers, pd, ps)
+	}
+
+	p := d.newProvider(dir, d.readOnly)
+	p.exclude = d.isProfileKey
+	providers = append(providers, p)
+
+	c.AddProviders(providers...)
+
+	return c, nil
+}
+
+func (d *Directory) newProvider(dir string, readOnly bool) *DirectoryConfigProvider {
+	p := NewDirectoryConfigProvider(dir, readOnly)
 	p.RegisterFiletype(&JSON{})
 	p.RegisterFiletype(&YAML{})
 	p.RegisterFiletype(&TOML{})
 	p.RegisterFiletype(&XML{})
+	p.RegisterFiletype(&HCL{})
+	p.RegisterFiletype(&INI{})
+	p.RegisterFiletype(&JSON5{})
+	p.RegisterFiletype(&DotEnv{})
+	p.SetKeyRing(d.keyRing)
+
+	return p
+}
 
-	c.AddProviders(e, p)
+// isProfileKey checks if a key of the base directory belongs to a profile.
+func (d *Directory) isProfileKey(key string) bool {
+	for _, profile := range d.profiles {
+		if strings.HasSuffix(key, "."+profile) {
+			return true
+		}
+	}
 
-	return c, nil
+	return false
 }
 
 var _ WritableProvider = &DirectoryConfigProvider{}
+var _ ChangeNotifier = &DirectoryConfigProvider{}
+var _ Poller = &DirectoryConfigProvider{}
+var _ Describer = &DirectoryConfigProvider{}
+var _ encryptingProvider = &DirectoryConfigProvider{}
+var _ KeyLister = &DirectoryConfigProvider{}
 
 type FileType interface {
 	Extensions() []string
@@ -84,15 +153,53 @@ type FileType interface {
 }
 
 type DirectoryConfigProvider struct {
+	changeListeners
 	base      string
 	readOnly  bool
 	fileTypes []FileType
+	keyRing   KeyRing
+	stateMtx  sync.Mutex
+	states    map[string]fileState
+	// dependencies holds the states of the other files read by the last
+	// load of a key: the included files and the keys used by templates.
+	dependencies map[string][]fileState
+	// suffix is appended to the file names of the keys, see Directory.SetProfiles.
+	suffix string
+	// exclude filters the keys returned by Keys.
+	exclude func(key string) bool
+}
+
+type fileState struct {
+	name    string
+	modTime time.Time
+	size    int64
+}
+
+// fileStateOf returns the state of a file, or the zero state if it does not
+// exist.
+func fileStateOf(fn string) fileState {
+	info, err := os.Stat(fn)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{
+		name:    fn,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+func (s fileState) changed(current fileState) bool {
+	return !current.modTime.Equal(s.modTime) || current.name != s.name || current.size != s.size
 }
 
 func NewDirectoryConfigProvider(base string, readOnly bool) *DirectoryConfigProvider {
 	return &DirectoryConfigProvider{
-		base:     base,
-		readOnly: readOnly,
+		base:         base,
+		readOnly:     readOnly,
+		states:       make(map[string]fileState),
+		dependencies: make(map[string][]fileState),
 	}
 }
 
@@ -101,7 +208,18 @@ func (d *DirectoryConfigProvider) RegisterFiletype(t FileType) {
 }
 
 func (d *DirectoryConfigProvider) basenameForKey(key string) string {
-	return filepath.FromSlash(filepath.Join(d.base, key))
+	name := filepath.FromSlash(filepath.Join(d.base, key))
+	if d.suffix != "" {
+		name += "." + d.suffix
+	}
+
+	return name
+}
+
+func (d *DirectoryConfigProvider) lockFileForKey(key string) string {
+	name := d.basenameForKey(key)
+
+	return filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".lock")
 }
 
 func (d *DirectoryConfigProvider) exists(key string) (FileType, string) {
@@ -112,51 +230,339 @@ func (d *DirectoryConfigProvider) exists(key string) (FileType, string) {
 			if _, err := os.Stat(fn); err == nil {
 				return t, fn
 			}
+			if _, err := os.Stat(fn + "." + TemplateExtension); err == nil {
+				return t, fn + "." + TemplateExtension
+			}
 		}
 	}
 
 	return nil, ""
 }
 
+func (d *DirectoryConfigProvider) currentState(key string) fileState {
+	_, fn := d.exists(key)
+	if fn == "" {
+		return fileState{}
+	}
+
+	return fileStateOf(fn)
+}
+
+func (d *DirectoryConfigProvider) track(key string) fileState {
+	state := d.currentState(key)
+
+	d.stateMtx.Lock()
+	d.states[key] = state
+	d.stateMtx.Unlock()
+
+	return state
+}
+
+// trackDependencies records the states of the files that a load of a key
+// read besides the file of the key. A missing file is recorded with its name,
+// so creating it is a change.
+func (d *DirectoryConfigProvider) trackDependencies(key string, files []string) {
+	states := make([]fileState, len(files))
+	for i, fn := range files {
+		states[i] = fileStateOf(fn)
+		states[i].name = fn
+	}
+
+	d.stateMtx.Lock()
+	d.dependencies[key] = states
+	d.stateMtx.Unlock()
+}
+
 func (d *DirectoryConfigProvider) Has(key string) bool {
+	return d.track(key).name != ""
+}
+
+// Keys lists the files with a registered extension, without the extension.
+// Files that only have an extension, like .env, are not listed. The files of
+// the subdirectories are not listed either, since the key separator is a dot,
+// and the subdirectories hold the namespaces and the profiles.
+func (d *DirectoryConfigProvider) Keys() ([]string, error) {
+	extensions := make(map[string]bool)
+	for _, t := range d.fileTypes {
+		for _, ext := range t.Extensions() {
+			extensions["."+ext] = true
+		}
+	}
+
+	files, err := ioutil.ReadDir(d.base)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	var keys []string
+	for _, info := range files {
+		if info.IsDir() {
+			continue
+		}
+
+		name := strings.TrimSuffix(info.Name(), "."+TemplateExtension)
+		ext := filepath.Ext(name)
+		if !extensions[ext] || name == ext {
+			continue
+		}
+
+		key := strings.TrimSuffix(name, ext)
+		if d.suffix != "" {
+			if !strings.HasSuffix(key, "."+d.suffix) {
+				continue
+			}
+			key = strings.TrimSuffix(key, "."+d.suffix)
+		}
+		if d.exclude != nil && d.exclude(key) {
+			continue
+		}
+		if !found[key] {
+			found[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// Poll compares the files of the previously seen keys, and the files their
+// last loads depended on, with their last known state, and notifies the
+// listeners about the changed keys.
+func (d *DirectoryConfigProvider) Poll() error {
+	d.stateMtx.Lock()
+	var changed []string
+	for key, state := range d.states {
+		current := d.currentState(key)
+		dependencies := d.dependencies[key]
+		dependencyChanged := false
+		for i, dep := range dependencies {
+			currentDep := fileStateOf(dep.name)
+			currentDep.name = dep.name
+			if dep.changed(currentDep) {
+				dependencies[i] = currentDep
+				dependencyChanged = true
+			}
+		}
+		if state.changed(current) || dependencyChanged {
+			d.states[key] = current
+			changed = append(changed, key)
+		}
+	}
+	d.stateMtx.Unlock()
+
+	for _, key := range changed {
+		d.notify(key)
+	}
+
+	return nil
+}
+
+func (d *DirectoryConfigProvider) Describe(key string) string {
 	_, fn := d.exists(key)
-	return fn != ""
+	if fn == "" {
+		fn = d.basenameForKey(key)
+	}
+
+	return "file:" + filepath.ToSlash(fn)
 }
 
+// Unmarshal decodes the file of a key, after expanding its templates and
+// resolving its includes.
 func (d *DirectoryConfigProvider) Unmarshal(key string, v interface{}) error {
 	ft, fn := d.exists(key)
-	f, err := os.Open(fn)
+	if fn == "" {
+		return &KeyNotFoundError{Key: key}
+	}
+
+	p := newPreprocessor(d)
+	data, err := p.process(fn, ft)
+	d.trackDependencies(key, p.files[1:])
 	if err != nil {
 		return err
 	}
-	defer util.MustClose(f)
 
-	return ft.Unmarshal(f, v)
+	if err = ft.Unmarshal(bytes.NewReader(data), v); err != nil {
+		return err
+	}
+
+	return decryptFields(d.keyRing, v)
+}
+
+// SetKeyRing sets the key ring of the encrypted fields.
+func (d *DirectoryConfigProvider) SetKeyRing(kr KeyRing) {
+	d.keyRing = kr
+}
+
+func (d *DirectoryConfigProvider) reEncrypt(key string, t reflect.Type) error {
+	ptr := reflect.New(t)
+	if err := d.Unmarshal(key, ptr.Interface()); err != nil {
+		return err
+	}
+
+	return d.Save(key, ptr.Elem().Interface())
 }
 
 func (d *DirectoryConfigProvider) CanSave(_ string) bool {
 	return !d.readOnly
 }
 
+// Save writes the value of a key atomically.
+//
+// The value is written to a temporary file in the same directory, which
+// replaces the original file. Concurrent saves of the same key are
+// serialized with an advisory lock on the hidden ".<key>.lock" file next to
+// it. The lock files are kept after the saves, because removing them would
+// let two processes lock different files of the same key.
 func (d *DirectoryConfigProvider) Save(key string, v interface{}) error {
-	var f *os.File
-	var err error
+	return d.save(key, v, nil)
+}
 
+// Revision returns the modification time and the content hash of the file
+// of a key.
+func (d *DirectoryConfigProvider) Revision(key string) (string, error) {
+	_, fn := d.exists(key)
+	if fn == "" {
+		return "", nil
+	}
+
+	return fileRevision(fn)
+}
+
+// SaveRevision saves a value like Save, if the file was not modified since
+// the given revision.
+func (d *DirectoryConfigProvider) SaveRevision(key string, v interface{}, revision string) error {
+	return d.save(key, v, &revision)
+}
+
+func (d *DirectoryConfigProvider) save(key string, v interface{}, revision *string) error {
+	v, err := encryptFields(d.keyRing, v)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(d.lockFileForKey(key))
+	if err != nil {
+		return errors.Wrap(err, "failed to lock config file")
+	}
+	defer unlock()
+
+	if revision != nil {
+		current, err := d.Revision(key)
+		if err != nil {
+			return err
+		}
+		if current != *revision {
+			return &ConflictError{
+				Key:      key,
+				Expected: *revision,
+				Actual:   current,
+			}
+		}
+	}
+
+	perm := os.FileMode(0644)
 	ft, fn := d.exists(key)
 	if fn == "" { // file does not exists
 		if len(d.fileTypes) == 0 {
 			return errors.New("no configured file type for this directory config provider")
 		}
-		name := d.basenameForKey(key) + "." + d.fileTypes[0].Extensions()[0]
+		fn = d.basenameForKey(key) + "." + d.fileTypes[0].Extensions()[0]
 		ft = d.fileTypes[0]
-		f, err = os.Create(name)
-	} else { // file exists
-		f, err = os.OpenFile(fn, os.O_RDWR, 0)
+	} else {
+		preprocessed, err := isPreprocessed(fn, ft)
+		if err != nil {
+			return err
+		}
+		if preprocessed {
+			return errors.Errorf("cannot save %s: the file uses templates or includes", fn)
+		}
+		if info, err := os.Stat(fn); err == nil {
+			perm = info.Mode().Perm()
+		}
 	}
+
+	err = writeFileAtomic(fn, perm, func(w io.Writer) error {
+		return ft.Marshal(w, v)
+	})
 	if err != nil {
 		return err
 	}
+
+	d.track(key)
+	d.notify(key)
+
+	return nil
+}
+
+func fileRevision(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
 	defer util.MustClose(f)
 
-	return ft.Marshal(f, v)
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%x", info.ModTime().UnixNano(), h.Sum(nil)[:8]), nil
+}
+
+func writeFileAtomic(fn string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	dir, base := filepath.Split(fn)
+	f, err := ioutil.TempFile(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err = write(f); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), fn); err != nil {
+		return err
+	}
+
+	syncDir(dir)
+
+	return nil
+}
+
+// syncDir makes the rename durable. Errors are ignored, since not every
+// platform supports syncing directories.
+func syncDir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
 }
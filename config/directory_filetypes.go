@@ -29,6 +29,19 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// MediaTyper is implemented by the file types that have registered media
+// types. The first media type is the preferred one.
+type MediaTyper interface {
+	MediaTypes() []string
+}
+
+var _ MediaTyper = &JSON{}
+var _ MediaTyper = &YAML{}
+var _ MediaTyper = &TOML{}
+var _ MediaTyper = &XML{}
+var _ MediaTyper = &HCL{}
+var _ MediaTyper = &JSON5{}
+
 type JSON struct {
 	Strict bool
 	Prefix string
@@ -39,6 +52,10 @@ func (t *JSON) Extensions() []string {
 	return []string{"json"}
 }
 
+func (t *JSON) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
 func (t *JSON) Unmarshal(stream io.Reader, v interface{}) error {
 	dec := json.NewDecoder(stream)
 	if t.Strict {
@@ -61,6 +78,10 @@ func (t *YAML) Extensions() []string {
 	return []string{"yml", "yaml"}
 }
 
+func (t *YAML) MediaTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml"}
+}
+
 func (t *YAML) Unmarshal(stream io.Reader, v interface{}) error {
 	data, err := ioutil.ReadAll(stream)
 	if err != nil {
@@ -93,6 +114,10 @@ func (t *TOML) Extensions() []string {
 	return []string{"toml"}
 }
 
+func (t *TOML) MediaTypes() []string {
+	return []string{"application/toml"}
+}
+
 func (t *TOML) Unmarshal(stream io.Reader, v interface{}) error {
 	return toml.NewDecoder(stream).Decode(v)
 }
@@ -119,6 +144,10 @@ func (t *XML) Extensions() []string {
 	return []string{"xml"}
 }
 
+func (t *XML) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
 func (t *XML) Unmarshal(stream io.Reader, v interface{}) error {
 	dec := xml.NewDecoder(stream)
 	dec.Strict = t.Strict
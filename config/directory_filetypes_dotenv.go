@@ -0,0 +1,145 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/config/env"
+)
+
+// DotEnv is the file type of .env files.
+//
+// The variables are mapped to the fields the same way as the environment
+// variables of EnvConfigProvider, without a prefix: the field D.E is read from
+// the variable D_E. The values of the double quoted and unquoted variables
+// can refer to the variables defined before them as $NAME or ${NAME}.
+type DotEnv struct {
+	Separator string
+}
+
+func (t *DotEnv) Extensions() []string {
+	return []string{"env"}
+}
+
+func (t *DotEnv) separator() string {
+	if t.Separator == "" {
+		return "_"
+	}
+
+	return t.Separator
+}
+
+func (t *DotEnv) Unmarshal(stream io.Reader, v interface{}) error {
+	vars, err := godotenv.Parse(stream)
+	if err != nil {
+		return err
+	}
+
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() &&
+		(rv.Elem().Kind() == reflect.Interface || rv.Elem().Kind() == reflect.Map) {
+		doc := make(map[string]interface{}, len(vars))
+		for name, value := range vars {
+			doc[name] = value
+		}
+		return decodeDocument(doc, v)
+	}
+
+	u := env.NewUnmarshaler()
+	u.Separator = t.separator()
+	u.Loader = func(name string) (string, bool) {
+		value, found := vars[name]
+		return value, found
+	}
+	u.Lister = func() []string {
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	return u.Unmarshal(v)
+}
+
+func (t *DotEnv) Marshal(stream io.Writer, v interface{}) error {
+	m := env.NewMarshaler()
+	m.Separator = t.separator()
+	vars, err := m.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+
+	w := bufio.NewWriter(stream)
+	for _, variable := range vars {
+		value, err := quoteDotEnv(variable.Name, variable.Value)
+		if err != nil {
+			return err
+		}
+		if _, err = w.WriteString(variable.Name + "=" + value + "\n"); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// scalarList formats a list if all of its items are scalars.
+func scalarList(list []interface{}) ([]string, bool) {
+	scalars := make([]string, 0, len(list))
+	for _, item := range list {
+		switch item.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, false
+		}
+		scalars = append(scalars, formatScalar(item))
+	}
+
+	return scalars, true
+}
+
+// quoteDotEnv formats a value of a .env file. The closing quote of a double
+// quoted value cannot follow an escaped quote or backslash, so these values
+// are single quoted or left unquoted where possible.
+func quoteDotEnv(name, value string) (string, error) {
+	switch {
+	case value != "" && !strings.ContainsAny(value, " \t\r\n\"'#\\$="):
+		return value, nil
+	case !strings.HasSuffix(value, `"`) && !strings.HasSuffix(value, `\`):
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`)
+		return `"` + r.Replace(value) + `"`, nil
+	case !strings.ContainsAny(value, "'\r\n") && !strings.HasSuffix(value, `\`):
+		return "'" + value + "'", nil
+	case !strings.ContainsAny(value, " \t\r\n#$") && value[0] != '"' && value[0] != '\'':
+		return value, nil
+	}
+
+	return "", errors.Errorf("the value of %s cannot be written to a .env file", name)
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/pkg/errors"
+)
+
+// HCL is the file type of HCL files.
+//
+// The attributes (key = value) and blocks (key "label" { ... }) of the
+// document are mapped to nested structs. Blocks with labels are mapped to
+// nested maps keyed by the labels, repeated blocks are merged. Interpolations
+// are not evaluated.
+type HCL struct{}
+
+func (t *HCL) Extensions() []string {
+	return []string{"hcl"}
+}
+
+func (t *HCL) MediaTypes() []string {
+	return []string{"application/hcl"}
+}
+
+func (t *HCL) Unmarshal(stream io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]interface{}
+	if err = hcl.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	return decodeDocument(mergeHCLBlocks(doc), v)
+}
+
+func (t *HCL) Marshal(stream io.Writer, v interface{}) error {
+	doc, err := encodeDocument(v)
+	if err != nil {
+		return err
+	}
+
+	body, ok := doc.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("hcl: cannot marshal %T", v)
+	}
+
+	w := bufio.NewWriter(stream)
+	writeHCLBody(w, body, "")
+
+	return w.Flush()
+}
+
+func writeHCLBody(w *bufio.Writer, body map[string]interface{}, indent string) {
+	keys := make([]string, 0, len(body))
+	for k := range body {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch value := body[k].(type) {
+		case nil:
+		case map[string]interface{}:
+			_, _ = w.WriteString(indent + hclKey(k) + " {\n")
+			writeHCLBody(w, value, indent+"  ")
+			_, _ = w.WriteString(indent + "}\n")
+		default:
+			_, _ = w.WriteString(indent + hclKey(k) + " = " + hclValue(value) + "\n")
+		}
+	}
+}
+
+func hclKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isHCLIdentifierByte(key[i]) {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" || (key[0] >= '0' && key[0] <= '9') {
+		return strconv.Quote(key)
+	}
+
+	return key
+}
+
+// hclValue formats a value. HCL has no null, so the nil values of maps are
+// left out, and the ones in lists are written as empty strings.
+func hclValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return `""`
+	case string:
+		return strconv.Quote(value)
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = hclValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k, item := range value {
+			if item != nil {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = hclKey(k) + " = " + hclValue(value[k])
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	}
+
+	return formatScalar(v)
+}
+
+func isHCLIdentifierByte(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// mergeHCLBlocks merges the objects that the decoder returns as lists of
+// maps, one for every block, into nested maps.
+func mergeHCLBlocks(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []map[string]interface{}:
+		m := make(map[string]interface{})
+		for _, block := range value {
+			mergeDocuments(m, mergeHCLBlocks(block).(map[string]interface{}))
+		}
+		return m
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = mergeHCLBlocks(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = mergeHCLBlocks(item)
+		}
+		return value
+	}
+
+	return v
+}
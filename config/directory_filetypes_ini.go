@@ -0,0 +1,178 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/ini.v1"
+)
+
+// INI is the file type of .ini files.
+//
+// Sections are mapped to nested structs, with dots separating the nested
+// sections ([database.pool]). Values are parsed according to the type of
+// their field, lists are comma-separated. Multi-line values are enclosed in
+// triple quotes.
+type INI struct{}
+
+func (t *INI) Extensions() []string {
+	return []string{"ini"}
+}
+
+func (t *INI) Unmarshal(stream io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+
+	doc, err := parseINI(data)
+	if err != nil {
+		return err
+	}
+
+	return decodeDocument(doc, v)
+}
+
+func (t *INI) Marshal(stream io.Writer, v interface{}) error {
+	doc, err := encodeDocument(v)
+	if err != nil {
+		return err
+	}
+
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("ini: cannot marshal %T", v)
+	}
+
+	file := ini.Empty()
+	if err = addINISection(file, ini.DefaultSection, "", root); err != nil {
+		return err
+	}
+
+	_, err = file.WriteTo(stream)
+
+	return err
+}
+
+// addINISection adds the values of a section, and the nested sections after
+// them. Sections without values are left out.
+func addINISection(file *ini.File, sectionName, path string, section map[string]interface{}) error {
+	keys := make([]string, 0, len(section))
+	for k := range section {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sec *ini.Section
+	var subsections []string
+	for _, k := range keys {
+		var value string
+		switch v := section[k].(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			subsections = append(subsections, k)
+			continue
+		case []interface{}:
+			scalars, ok := scalarList(v)
+			if !ok {
+				return errors.Errorf("ini: %s: lists of sections are not supported", joinSection(path, k))
+			}
+			value = strings.Join(scalars, ",")
+		default:
+			value = formatScalar(v)
+		}
+
+		if sec == nil {
+			var err error
+			if sec, err = file.NewSection(sectionName); err != nil {
+				return err
+			}
+		}
+		if _, err := sec.NewKey(k, value); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range subsections {
+		name := joinSection(path, k)
+		if err := addINISection(file, name, name, section[k].(map[string]interface{})); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func joinSection(name, child string) string {
+	if name == "" {
+		return child
+	}
+
+	return name + "." + child
+}
+
+// parseINI parses an INI file into nested maps of strings.
+func parseINI(data []byte) (map[string]interface{}, error) {
+	file, err := ini.LoadSources(ini.LoadOptions{
+		SpaceBeforeInlineComment: true,
+	}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	root := make(map[string]interface{})
+	for _, sec := range file.Sections() {
+		current := root
+		if name := sec.Name(); name != ini.DefaultSection {
+			for _, part := range strings.Split(name, ".") {
+				part = strings.TrimSpace(part)
+				if part == "" {
+					return nil, errors.Errorf("ini: [%s]: empty section name", name)
+				}
+
+				switch section := current[part].(type) {
+				case nil:
+					sub := make(map[string]interface{})
+					current[part] = sub
+					current = sub
+				case map[string]interface{}:
+					current = section
+				default:
+					return nil, errors.Errorf("ini: [%s]: %s is already a value", name, part)
+				}
+			}
+		}
+
+		for _, key := range sec.Keys() {
+			if _, isSection := current[key.Name()].(map[string]interface{}); isSection {
+				return nil, errors.Errorf("ini: [%s]: %s is already a section", sec.Name(), key.Name())
+			}
+			current[key.Name()] = key.Value()
+		}
+	}
+
+	return root, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/titanous/json5"
+)
+
+// JSON5 is the file type of JSON5 and JSONC files.
+//
+// Besides JSON, comments, trailing commas, single quoted strings, unquoted
+// keys, hexadecimal numbers, Infinity, NaN and numbers with a leading plus
+// sign or a leading or trailing decimal point are accepted. Values are
+// written as plain JSON.
+type JSON5 struct {
+	Strict bool
+	Prefix string
+	Indent string
+}
+
+func (t *JSON5) Extensions() []string {
+	return []string{"json5", "jsonc"}
+}
+
+func (t *JSON5) MediaTypes() []string {
+	return []string{"application/json5"}
+}
+
+func (t *JSON5) Unmarshal(stream io.Reader, v interface{}) error {
+	dec := json5.NewDecoder(stream)
+	if t.Strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
+
+func (t *JSON5) Marshal(stream io.Writer, v interface{}) error {
+	enc := json.NewEncoder(stream)
+	enc.SetIndent(t.Prefix, t.Indent)
+	return enc.Encode(v)
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+)
+
+type fileTypeTest struct {
+	Name    string
+	Port    int
+	Hosts   []string
+	Script  string
+	Servers map[string]struct {
+		Address string
+		Weight  float64
+	}
+}
+
+func TestFileTypes(t *testing.T) {
+	expected := fileTypeTest{
+		Name:   "main",
+		Port:   255,
+		Hosts:  []string{"a", "b"},
+		Script: "echo 1\necho 2\n",
+		Servers: map[string]struct {
+			Address string
+			Weight  float64
+		}{
+			"primary": {Address: "10.0.0.1", Weight: 0.5},
+		},
+	}
+
+	entries := map[string]struct {
+		ft       config.FileType
+		document string
+	}{
+		"hcl": {&config.HCL{}, `
+			name = "main"
+			port = 0xff
+			hosts = ["a", "b",]
+			script = <<-EOT
+				echo 1
+				echo 2
+				EOT
+			servers "primary" {
+				address = "10.0.0.1"
+			}
+			servers "primary" {
+				weight = 0.5
+			}
+		`},
+		"ini": {&config.INI{}, `
+			name = main
+			port = 255
+			hosts = a,b
+			script = """echo 1
+echo 2
+"""
+
+			[servers.primary]
+			address = 10.0.0.1
+			weight: 0.5
+		`},
+		"json5": {&config.JSON5{}, `{
+			name: 'main', // comment
+			port: +0xFF,
+			hosts: ["a", "b",],
+			script: "echo 1\n\
+echo 2\n",
+			servers: {primary: {address: "10.0.0.1", weight: .5}},
+		}`},
+		"env": {&config.DotEnv{}, `
+			NAME=main
+			PORT=255
+			HOSTS=a,b
+			SERVERS_PRIMARY_ADDRESS=10.0.0.1
+			SERVERS_PRIMARY_WEIGHT=.5
+			SCRIPT="echo 1
+echo 2
+"
+		`},
+	}
+
+	for name, entry := range entries {
+		t.Run(name, func(t *testing.T) {
+			v := fileTypeTest{}
+			require.NoError(t, entry.ft.Unmarshal(strings.NewReader(entry.document), &v))
+			require.Equal(t, expected, v)
+
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, entry.ft.Marshal(buf, expected))
+			decoded := fileTypeTest{}
+			require.NoError(t, entry.ft.Unmarshal(buf, &decoded), buf.String())
+			require.Equal(t, expected, decoded, buf.String())
+		})
+	}
+}
@@ -0,0 +1,425 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// IncludeKey is the key at the top level of a document that merges other
+// files into it.
+//
+// The value is a path or a list of paths relative to the including file. The
+// included documents are merged in order, and the keys of the including
+// document take precedence. XML and .env files can neither include nor be
+// included, since their documents cannot be encoded again.
+const IncludeKey = "$include"
+
+// TemplateExtension is the extra extension of the files that are expanded as
+// templates, like "database.yaml.tmpl". Other files are not expanded, so they
+// can contain literal "{{" values.
+const TemplateExtension = "tmpl"
+
+var yamlIncludeLine = regexp.MustCompile(`^(\s*(?:-\s+|[^#\s][^#]*?:\s+)?)!include\s+(.+?)\s*$`)
+
+// preprocessor expands the templates and resolves the includes of the files
+// of a DirectoryConfigProvider.
+//
+// Files with the TemplateExtension are Go templates, with the "env" function
+// returning environment variables, and the "key" function returning the
+// document of another key of the provider.
+//
+// YAML files can include other files with "!include <path>" values, the
+// other file types with IncludeKey.
+type preprocessor struct {
+	d     *DirectoryConfigProvider
+	stack []string
+	// files holds the names of the files read, in order.
+	files []string
+}
+
+type include struct {
+	path string
+	line int
+}
+
+func newPreprocessor(d *DirectoryConfigProvider) *preprocessor {
+	return &preprocessor{d: d}
+}
+
+// process returns the preprocessed content of a file.
+func (p *preprocessor) process(fn string, ft FileType) ([]byte, error) {
+	p.files = append(p.files, fn)
+
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range p.stack {
+		if item == abs {
+			return nil, errors.Errorf("include cycle: %s", strings.Join(append(p.stack[i:], abs), " -> "))
+		}
+	}
+	p.stack = append(p.stack, abs)
+	defer func() { p.stack = p.stack[:len(p.stack)-1] }()
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if isTemplate(fn) {
+		if data, err = p.expand(fn, data); err != nil {
+			return nil, err
+		}
+	}
+
+	doc, includes, err := parseIncludes(data, ft)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: failed to resolve includes", fn)
+	}
+	if doc == nil {
+		return data, nil
+	}
+
+	if doc, err = p.resolve(fn, data, doc, includes); err != nil {
+		return nil, err
+	}
+	if m, ok := doc.(map[string]interface{}); ok {
+		if doc, err = p.include(fn, data, m); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err = ft.Marshal(buf, doc); err != nil {
+		return nil, errors.Wrapf(err, "%s: failed to resolve includes", fn)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseIncludes decodes a file that has includes: "!include" values in YAML
+// files, or an IncludeKey at the top level of the document. The "!include"
+// values are replaced with the markers of the returned includes.
+//
+// Returns a nil document if the file has no includes.
+func parseIncludes(data []byte, ft FileType) (interface{}, map[string]include, error) {
+	if !includesSupported(ft) {
+		return nil, nil, nil
+	}
+
+	var includes map[string]include
+	if _, ok := ft.(*YAML); ok {
+		data, includes = markYAMLIncludes(data)
+	}
+
+	if len(includes) == 0 && !bytes.Contains(data, []byte(IncludeKey)) {
+		return nil, nil, nil
+	}
+
+	var doc interface{}
+	if err := ft.Unmarshal(bytes.NewReader(data), &doc); err != nil {
+		return nil, nil, err
+	}
+	doc = normalizeDocument(doc)
+
+	if len(includes) == 0 {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, nil, nil
+		}
+		if _, found := m[IncludeKey]; !found {
+			return nil, nil, nil
+		}
+	}
+
+	return doc, includes, nil
+}
+
+// includesSupported checks if the documents of a file type can be decoded
+// and encoded again, which resolving the includes needs.
+func includesSupported(ft FileType) bool {
+	switch ft.(type) {
+	case *XML, *DotEnv:
+		return false
+	}
+
+	return true
+}
+
+func (p *preprocessor) expand(fn string, data []byte) ([]byte, error) {
+	tpl, err := template.New(fn).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			"env": os.Getenv,
+			"key": p.key,
+		}).
+		Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err = tpl.Execute(buf, nil); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (p *preprocessor) key(key string) (interface{}, error) {
+	_, fn := p.d.exists(key)
+	if fn == "" {
+		return nil, &KeyNotFoundError{Key: key}
+	}
+
+	return p.load(fn)
+}
+
+// load returns the preprocessed document of a file.
+func (p *preprocessor) load(fn string) (interface{}, error) {
+	ft := p.d.fileTypeFor(fn)
+	if ft == nil {
+		return nil, errors.Errorf("%s: unknown file type", fn)
+	}
+	if !includesSupported(ft) {
+		return nil, errors.Errorf("%s: the file type cannot be included", fn)
+	}
+
+	data, err := p.process(fn, ft)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err = ft.Unmarshal(bytes.NewReader(data), &doc); err != nil {
+		return nil, errors.Wrap(err, fn)
+	}
+
+	return normalizeDocument(doc), nil
+}
+
+func (p *preprocessor) resolve(fn string, data []byte, v interface{}, includes map[string]include) (interface{}, error) {
+	switch value := v.(type) {
+	case string:
+		inc, found := includes[value]
+		if !found {
+			return value, nil
+		}
+
+		doc, err := p.load(filepath.Join(filepath.Dir(fn), inc.path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", fn, inc.line)
+		}
+
+		return doc, nil
+	case []interface{}:
+		for i, item := range value {
+			resolved, err := p.resolve(fn, data, item, includes)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = resolved
+		}
+
+		return value, nil
+	case map[string]interface{}:
+		for k, item := range value {
+			resolved, err := p.resolve(fn, data, item, includes)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = resolved
+		}
+
+		return value, nil
+	}
+
+	return v, nil
+}
+
+// include merges the files of the IncludeKey of a document into it.
+func (p *preprocessor) include(fn string, data []byte, doc map[string]interface{}) (map[string]interface{}, error) {
+	paths, found := doc[IncludeKey]
+	if !found {
+		return doc, nil
+	}
+	delete(doc, IncludeKey)
+
+	var list []interface{}
+	if l, ok := paths.([]interface{}); ok {
+		list = l
+	} else {
+		list = []interface{}{paths}
+	}
+
+	merged := make(map[string]interface{})
+	for _, item := range list {
+		path, ok := item.(string)
+		if !ok {
+			return nil, errors.Errorf("%s:%d: %s must be a path or a list of paths", fn, lineOf(data, IncludeKey, ""), IncludeKey)
+		}
+
+		included, err := p.load(filepath.Join(filepath.Dir(fn), path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", fn, lineOf(data, IncludeKey, path))
+		}
+
+		m, ok := included.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("%s:%d: included file %s is not a map", fn, lineOf(data, IncludeKey, path), path)
+		}
+		mergeDocuments(merged, m)
+	}
+	mergeDocuments(merged, doc)
+
+	return merged, nil
+}
+
+func isTemplate(fn string) bool {
+	return strings.HasSuffix(fn, "."+TemplateExtension)
+}
+
+// isPreprocessed checks if a file is a template or includes other files.
+// Saving such a file would replace the directives with their results.
+func isPreprocessed(fn string, ft FileType) (bool, error) {
+	if isTemplate(fn) {
+		return true, nil
+	}
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return false, err
+	}
+
+	doc, _, err := parseIncludes(data, ft)
+	if err != nil {
+		return false, errors.Wrap(err, fn)
+	}
+
+	return doc != nil, nil
+}
+
+func (d *DirectoryConfigProvider) fileTypeFor(fn string) FileType {
+	ext := strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(fn, "."+TemplateExtension)), ".")
+	for _, t := range d.fileTypes {
+		for _, e := range t.Extensions() {
+			if e == ext {
+				return t
+			}
+		}
+	}
+
+	return nil
+}
+
+// markYAMLIncludes replaces the "!include <path>" values with unique markers.
+func markYAMLIncludes(data []byte) ([]byte, map[string]include) {
+	lines := strings.Split(string(data), "\n")
+	includes := make(map[string]include)
+	for i, line := range lines {
+		match := yamlIncludeLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		marker := fmt.Sprintf("__include_%d__", i+1)
+		includes[marker] = include{
+			path: strings.Trim(match[2], `"'`),
+			line: i + 1,
+		}
+		lines[i] = match[1] + strconv.Quote(marker)
+	}
+
+	if len(includes) == 0 {
+		return data, nil
+	}
+
+	return []byte(strings.Join(lines, "\n")), includes
+}
+
+// lineOf returns the number of the first line containing all the given
+// strings, or 0.
+func lineOf(data []byte, needles ...string) int {
+	for i, line := range strings.Split(string(data), "\n") {
+		found := true
+		for _, needle := range needles {
+			if !strings.Contains(line, needle) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// normalizeDocument converts the maps of a decoded document to
+// map[string]interface{}.
+func normalizeDocument(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = normalizeDocument(item)
+		}
+		return m
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = normalizeDocument(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalizeDocument(item)
+		}
+		return value
+	}
+
+	return v
+}
+
+// mergeDocuments merges src into dst recursively. The values of src take
+// precedence.
+func mergeDocuments(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dm, dok := dst[k].(map[string]interface{})
+		sm, sok := v.(map[string]interface{})
+		if dok && sok {
+			mergeDocuments(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,348 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+	"github.com/tamasd/constellation/util"
+)
+
+func TestDirectorySave(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, false)
+	dp.RegisterFiletype(&config.JSON{})
+	fn := filepath.Join(tmpdir, "test.json")
+
+	t.Run("shorter documents replace the file", func(t *testing.T) {
+		long := testExample()
+		long.B = strings.Repeat("x", 1024)
+		require.NoError(t, dp.Save("test", long))
+		require.NoError(t, dp.Save("test", testExample()))
+
+		v := test{}
+		require.NoError(t, dp.Unmarshal("test", &v))
+		require.Equal(t, testExample(), v)
+	})
+
+	t.Run("permissions are preserved", func(t *testing.T) {
+		require.NoError(t, os.Chmod(fn, 0600))
+		require.NoError(t, dp.Save("test", testExample()))
+
+		info, err := os.Stat(fn)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+
+	t.Run("concurrent saves do not corrupt the file", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				v := testExample()
+				v.B = strings.Repeat("y", i*100)
+				util.Must(dp.Save("test", v))
+			}(i)
+		}
+		wg.Wait()
+
+		v := test{}
+		require.NoError(t, dp.Unmarshal("test", &v))
+
+		files, err := ioutil.ReadDir(tmpdir)
+		require.NoError(t, err)
+		names := make([]string, len(files))
+		for i, f := range files {
+			names[i] = f.Name()
+		}
+		require.ElementsMatch(t, []string{".test.lock", "test.json"}, names)
+	})
+}
+
+func TestWritableConfigConflict(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, false)
+	dp.RegisterFiletype(&config.JSON{})
+	collection := config.NewCollection()
+	collection.AddProviders(dp)
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test", reflect.TypeOf(test{}))
+	c.AddCollection("config", collection)
+	wc := c.GetWritable("config")
+
+	_, first, err := wc.GetWritable("test")
+	require.NoError(t, err)
+	require.Empty(t, first.Revision())
+	_, second, err := wc.GetWritable("test")
+	require.NoError(t, err)
+
+	require.NoError(t, first.Save(testExample()))
+
+	err = second.Save(testExample())
+	require.IsType(t, &config.ConflictError{}, err)
+	conflict := err.(*config.ConflictError)
+	require.Equal(t, "config", conflict.Namespace)
+	require.Equal(t, "test", conflict.Key)
+	require.Empty(t, conflict.Expected)
+	require.NotEmpty(t, conflict.Actual)
+
+	_, third, err := wc.GetWritable("test")
+	require.NoError(t, err)
+	require.Equal(t, conflict.Actual, third.Revision())
+	v := testExample()
+	v.B = "updated"
+	require.NoError(t, third.Save(v))
+
+	stored, err := wc.Get("test")
+	require.NoError(t, err)
+	require.Equal(t, v, stored)
+}
+
+func TestWritableConfigStaleCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	fn := filepath.Join(tmpdir, "test.json")
+	require.NoError(t, ioutil.WriteFile(fn, []byte(`{"A": 1, "B": "original"}`), 0644))
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, false)
+	dp.RegisterFiletype(&config.JSON{})
+	collection := config.NewCollection()
+	collection.AddProviders(dp)
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test", reflect.TypeOf(test{}))
+	c.AddCollection("config", collection)
+	wc := c.GetWritable("config")
+
+	cached, err := wc.Get("test")
+	require.NoError(t, err)
+	require.Equal(t, "original", cached.(test).B)
+
+	require.NoError(t, ioutil.WriteFile(fn, []byte(`{"A": 1, "B": "external"}`), 0644))
+
+	v, saver, err := wc.GetWritable("test")
+	require.NoError(t, err)
+	require.Equal(t, "external", v.(test).B)
+
+	updated := v.(test)
+	updated.A = 2
+	require.NoError(t, saver.Save(updated))
+
+	stored, err := wc.Get("test")
+	require.NoError(t, err)
+	require.Equal(t, 2, stored.(test).A)
+	require.Equal(t, "external", stored.(test).B)
+}
+
+func TestDirectoryKeys(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	for _, name := range []string{".env", "app.env", "sub/.env", "sub/test.json", "sub.test.json"} {
+		fn := filepath.Join(tmpdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fn), 0755))
+		require.NoError(t, ioutil.WriteFile(fn, []byte{}, 0644))
+	}
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, false)
+	registerFileTypes(dp)
+	keys, err := dp.Keys()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"app", "sub.test"}, keys)
+}
+
+func TestDirectoryProfiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	files := map[string]string{
+		"ns/test.json":         `{"A": 1, "B": "base", "G": "base"}`,
+		"ns/prod/test.json":    `{"B": "prod", "G": "prod"}`,
+		"ns/test.eu-west.json": `{"G": "eu-west"}`,
+	}
+	for name, content := range files {
+		fn := filepath.Join(tmpdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fn), 0755))
+		require.NoError(t, ioutil.WriteFile(fn, []byte(content), 0644))
+	}
+
+	cl := config.NewDirectory(tmpdir, nil, false)
+	cl.SetProfiles("prod", "eu-west")
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test", reflect.TypeOf(test{}))
+	c.AddCollectionLoaders(cl)
+
+	t.Run("later profiles take precedence", func(t *testing.T) {
+		v, err := c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 1, v.(test).A)
+		require.Equal(t, "prod", v.(test).B)
+		require.Equal(t, "eu-west", v.(test).G)
+	})
+
+	t.Run("profile files are not listed as keys", func(t *testing.T) {
+		keys, err := c.Get("ns").Keys("*")
+		require.NoError(t, err)
+		require.Equal(t, []string{"test"}, keys)
+	})
+
+	t.Run("values are saved to the base directory", func(t *testing.T) {
+		v, saver, err := c.GetWritable("ns").GetWritable("test")
+		require.NoError(t, err)
+		require.NoError(t, saver.Save(v))
+
+		stored := test{}
+		dp := config.NewDirectoryConfigProvider(filepath.Join(tmpdir, "ns"), true)
+		dp.RegisterFiletype(&config.JSON{})
+		require.NoError(t, dp.Unmarshal("test", &stored))
+		require.Equal(t, "eu-west", stored.G)
+
+		content, err := ioutil.ReadFile(filepath.Join(tmpdir, "ns", "prod", "test.json"))
+		require.NoError(t, err)
+		require.Equal(t, files["ns/prod/test.json"], string(content))
+	})
+}
+
+func TestDirectoryPreprocessing(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	files := map[string]string{
+		"template.yaml.tmpl": "a: {{ env \"CONSTELLATION_TEST_A\" }}\ng: {{ (key \"other\").name }}\n",
+		"other.yaml":         "name: zxcvbn\n",
+		"yaml.yaml":          "a: 5\nd: !include inc/d.json\n",
+		"inc/d.json":         `{"e": -2, "f": -1.2}`,
+		"json.json":          `{"$include": ["base.yaml", "inc/override.toml"], "A": 5}`,
+		"base.yaml":          "b: base\nc: true\nd:\n  e: -2\n  f: -1.2\n",
+		"inc/override.toml":  "b = \"asdf\"\n",
+		"cycle.yaml":         "a: 1\n$include: cycle2.json\n",
+		"cycle2.json":        `{"$include": "cycle.yaml"}`,
+		"missing.yaml":       "a: 1\nd: !include nope.yaml\n",
+		"literal.json":       `{"G": "Hello {{.Name}}"}`,
+		"mention.json":       `{"B": "$include", "D": {"$include": "inc/d.json"}}`,
+		"xml.json":           `{"$include": "inc/value.xml"}`,
+		"inc/value.xml":      "<test><A>1</A></test>",
+	}
+	for name, content := range files {
+		fn := filepath.Join(tmpdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fn), 0755))
+		require.NoError(t, ioutil.WriteFile(fn, []byte(content), 0644))
+	}
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, true)
+	registerFileTypes(dp)
+
+	t.Run("templates", func(t *testing.T) {
+		require.NoError(t, os.Setenv("CONSTELLATION_TEST_A", "42"))
+		defer func() { util.Must(os.Unsetenv("CONSTELLATION_TEST_A")) }()
+
+		v := test{}
+		require.NoError(t, dp.Unmarshal("template", &v))
+		require.Equal(t, 42, v.A)
+		require.Equal(t, "zxcvbn", v.G)
+	})
+
+	t.Run("templates are opt-in", func(t *testing.T) {
+		v := test{}
+		require.NoError(t, dp.Unmarshal("literal", &v))
+		require.Equal(t, "Hello {{.Name}}", v.G)
+	})
+
+	t.Run("yaml include tag", func(t *testing.T) {
+		v := test{}
+		require.NoError(t, dp.Unmarshal("yaml", &v))
+		require.Equal(t, 5, v.A)
+		require.Equal(t, -2, v.D.E)
+		require.Equal(t, -1.2, v.D.F)
+	})
+
+	t.Run("include key", func(t *testing.T) {
+		v := test{}
+		require.NoError(t, dp.Unmarshal("json", &v))
+		expected := testExample()
+		expected.G = ""
+		require.Equal(t, expected, v)
+	})
+
+	t.Run("include key only applies at the top level", func(t *testing.T) {
+		v := test{}
+		require.NoError(t, dp.Unmarshal("mention", &v))
+		require.Equal(t, "$include", v.B)
+		require.Equal(t, 0, v.D.E)
+	})
+
+	t.Run("xml files cannot be included", func(t *testing.T) {
+		err := dp.Unmarshal("xml", &test{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "cannot be included")
+	})
+
+	t.Run("include cycle", func(t *testing.T) {
+		err := dp.Unmarshal("cycle", &test{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "include cycle")
+		require.Contains(t, err.Error(), "cycle.yaml:2")
+	})
+
+	t.Run("missing include", func(t *testing.T) {
+		err := dp.Unmarshal("missing", &test{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing.yaml:2")
+	})
+
+	t.Run("preprocessed files are not saved", func(t *testing.T) {
+		wp := config.NewDirectoryConfigProvider(tmpdir, false)
+		registerFileTypes(wp)
+		for key, name := range map[string]string{
+			"template": "template.yaml.tmpl",
+			"yaml":     "yaml.yaml",
+			"json":     "json.json",
+		} {
+			require.Error(t, wp.Save(key, testExample()), key)
+			content, err := ioutil.ReadFile(filepath.Join(tmpdir, name))
+			require.NoError(t, err)
+			require.Equal(t, files[name], string(content))
+		}
+		require.NoError(t, wp.Save("literal", testExample()))
+		require.NoError(t, wp.Save("mention", testExample()))
+	})
+}
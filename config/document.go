@@ -0,0 +1,166 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/config/validate"
+)
+
+// decodeDocument assigns a generic document, made of string keyed maps,
+// slices and scalars, to the value pointed by v.
+//
+// It is used by the file types that do not have a decoder of their own.
+// Scalars are converted with the rules of the default tags, so a string
+// document can be assigned to any scalar field.
+func decodeDocument(doc interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.Errorf("cannot decode a document into %T", v)
+	}
+
+	return decodeValue(doc, rv.Elem(), "")
+}
+
+func decodeValue(doc interface{}, rv reflect.Value, path string) error {
+	if doc == nil {
+		return nil
+	}
+
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		rv.Set(reflect.ValueOf(doc))
+		return nil
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeValue(doc, rv.Elem(), path)
+	}
+
+	switch value := doc.(type) {
+	case map[string]interface{}:
+		switch rv.Kind() {
+		case reflect.Struct:
+			for k, item := range value {
+				field, found := documentField(rv, k)
+				if !found {
+					continue
+				}
+				if err := decodeValue(item, field, validate.JoinPath(path, k)); err != nil {
+					return err
+				}
+			}
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				return errors.Errorf("%s: unsupported map key type %s", path, rv.Type().Key())
+			}
+			if rv.IsNil() {
+				rv.Set(reflect.MakeMap(rv.Type()))
+			}
+			for k, item := range value {
+				elem := reflect.New(rv.Type().Elem()).Elem()
+				if err := decodeValue(item, elem, path+"["+k+"]"); err != nil {
+					return err
+				}
+				rv.SetMapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()), elem)
+			}
+		default:
+			return errors.Errorf("%s: cannot assign a map to %s", path, rv.Type())
+		}
+	case []interface{}:
+		switch rv.Kind() {
+		case reflect.Slice:
+			slice := reflect.MakeSlice(rv.Type(), len(value), len(value))
+			for i, item := range value {
+				if err := decodeValue(item, slice.Index(i), path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+			rv.Set(slice)
+		case reflect.Array:
+			for i := 0; i < len(value) && i < rv.Len(); i++ {
+				if err := decodeValue(value[i], rv.Index(i), path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		default:
+			return errors.Errorf("%s: cannot assign a list to %s", path, rv.Type())
+		}
+	default:
+		if err := parseString(rv, formatScalar(value)); err != nil {
+			return errors.Wrap(err, path)
+		}
+	}
+
+	return nil
+}
+
+// documentField finds the field of a struct for a document key, by name,
+// by tag, or by name ignoring the underscores of the key.
+func documentField(rv reflect.Value, key string) (reflect.Value, bool) {
+	if field, found := lookupField(rv, key); found {
+		return field, true
+	}
+
+	if strings.Contains(key, "_") {
+		return lookupField(rv, strings.ReplaceAll(key, "_", ""))
+	}
+
+	return reflect.Value{}, false
+}
+
+func formatScalar(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// encodeDocument converts a value to a generic document, using the JSON
+// representation of the value.
+func encodeDocument(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/config/matcher"
+)
+
+// Dump returns the effective values of every key in a namespace that has a
+// registered schema, with the secrets and the encrypted fields redacted.
+//
+// The keys of the wildcard schemas are discovered from the providers that
+// implement KeyLister.
+func (s *Store) Dump(namespace string) (map[string]interface{}, error) {
+	collection := s.ensureNamespace(namespace)
+	if collection == nil {
+		return nil, CollectionNotFoundError{namespace}
+	}
+
+	keys, err := collection.candidateKeys()
+	if err != nil {
+		return nil, err
+	}
+	for name := range s.Schemas() {
+		if !strings.Contains(name, "*") {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+
+	dump := make(map[string]interface{})
+	for _, key := range keys {
+		if _, done := dump[key]; done {
+			continue
+		}
+
+		sc := s.schemas.Get(key)
+		if sc == nil || !collection.has(key, sc.(*schema).typ) {
+			continue
+		}
+
+		res, err := collection.resolve(key, sc.(*schema))
+		if err != nil {
+			return nil, errors.Wrap(withNamespace(err, namespace), "failed to load "+key)
+		}
+		if res == nil {
+			continue
+		}
+
+		dump[key] = redact(res)
+	}
+
+	return dump, nil
+}
+
+func (s *Store) keys(namespace, pattern string) ([]string, error) {
+	collection := s.ensureNamespace(namespace)
+	if collection == nil {
+		return nil, CollectionNotFoundError{namespace}
+	}
+
+	candidates, err := collection.candidateKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	m := matcher.NewMatcher(".")
+	m.Set(pattern, true)
+
+	found := make(map[string]bool)
+	keys := []string{}
+	for _, key := range candidates {
+		if !found[key] && m.Get(key) != nil {
+			found[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (c *Collection) candidateKeys() ([]string, error) {
+	var keys []string
+	for _, provider := range c.getProviders() {
+		if kl, ok := provider.(KeyLister); ok {
+			providerKeys, err := kl.Keys()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, providerKeys...)
+		}
+	}
+
+	return keys, nil
+}
+
+func (c *Collection) has(key string, t reflect.Type) bool {
+	for _, provider := range c.getProviders() {
+		if providerHas(provider, key, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redact(res *resolution) interface{} {
+	ptr := reflect.New(res.value.Elem().Type())
+	ptr.Elem().Set(deepCopy(res.value.Elem()))
+
+	_ = rewriteStrings("", ptr.Elem(), func(path, s string) (string, error) {
+		if _, secret := res.secrets[path]; secret {
+			return Redacted, nil
+		}
+		return s, nil
+	})
+	_ = transformEncryptedFields(ptr, func(s string) (string, error) {
+		if s == "" {
+			return s, nil
+		}
+		return Redacted, nil
+	})
+
+	return ptr.Elem().Interface()
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+)
+
+func TestDump(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test.*", reflect.TypeOf(test{}))
+	c.RegisterSchema("credentials", reflect.TypeOf(encryptedTest{}))
+	c.RegisterSchema("missing", reflect.TypeOf(test{}))
+
+	require.NoError(t, os.Setenv("DUMP_TEST_SECRET", "hunter2"))
+	defer func() { _ = os.Unsetenv("DUMP_TEST_SECRET") }()
+
+	credentials := encryptedTest{User: "${env:DUMP_TEST_SECRET}", Password: "hunter2"}
+	mp := config.NewMemoryConfigProvider()
+	require.NoError(t, mp.Save("credentials", credentials))
+
+	dp := config.NewDirectoryConfigProvider("fixtures/config", true)
+	registerFileTypes(dp)
+	collection := config.NewCollection()
+	collection.AddProviders(mp, dp)
+	c.AddCollection("config", collection)
+
+	dump, err := c.Dump("config")
+	require.NoError(t, err)
+
+	require.Len(t, dump, 9)
+	for _, key := range []string{"test.0", "test.1", "test.2", "test.3", "test.4", "test.5", "test.6", "test.7"} {
+		require.Contains(t, dump, key)
+	}
+	require.Equal(t, 5, dump["test.1"].(test).A)
+
+	redacted := dump["credentials"].(encryptedTest)
+	require.Equal(t, config.Redacted, redacted.User)
+	require.Equal(t, config.Redacted, redacted.Password)
+	require.Equal(t, "", redacted.Nested.Token)
+}
+
+func TestKeys(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test.*", reflect.TypeOf(test{}))
+
+	require.NoError(t, os.Setenv("KEYS_TEST.ENV_A", "5"))
+	require.NoError(t, os.Setenv("KEYS_OTHER_A", "5"))
+	defer func() {
+		_ = os.Unsetenv("KEYS_TEST.ENV_A")
+		_ = os.Unsetenv("KEYS_OTHER_A")
+	}()
+
+	ep := config.NewEnvConfigProvider()
+	ep.Prefix = "KEYS"
+	ep.Reset()
+	mp := config.NewMemoryConfigProvider()
+	require.NoError(t, mp.Save("test.memory", testExample()))
+	require.NoError(t, mp.Save("test.0", testExample()))
+	dp := config.NewDirectoryConfigProvider("fixtures/config", true)
+	registerFileTypes(dp)
+	collection := config.NewCollection()
+	collection.AddProviders(ep, mp, dp)
+	c.AddCollection("config", collection)
+
+	keys, err := c.Get("config").Keys("test.*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test.0", "test.1", "test.2", "test.3", "test.4", "test.5", "test.6", "test.7", "test.env", "test.memory"}, keys)
+
+	keys, err = c.Get("config").Keys("**.env")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test.env"}, keys)
+
+	keys, err = c.Get("config").Keys("other")
+	require.NoError(t, err)
+	require.Equal(t, []string{"other"}, keys)
+
+	keys, err = c.Get("config").Keys("missing.*")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
@@ -0,0 +1,313 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const encryptedPrefix = "enc:v1:"
+
+// KeyRing supplies the keys of the encrypted config fields.
+//
+// Fields tagged with `config:",encrypted"` are stored encrypted with AES-GCM,
+// so the keys must be 16, 24 or 32 bytes long.
+type KeyRing interface {
+	// CurrentKey returns the key used to encrypt new values.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns a key by its id.
+	Key(id string) ([]byte, error)
+}
+
+var _ KeyRing = &StaticKeyRing{}
+
+// StaticKeyRing is an in-memory key ring.
+type StaticKeyRing struct {
+	mtx     sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+func NewStaticKeyRing(current string, keys map[string][]byte) *StaticKeyRing {
+	kr := &StaticKeyRing{
+		current: current,
+		keys:    make(map[string][]byte),
+	}
+	for id, key := range keys {
+		kr.keys[id] = key
+	}
+
+	return kr
+}
+
+// Rotate adds a key, and makes it the current one.
+//
+// The previous keys can still be used for decryption.
+func (kr *StaticKeyRing) Rotate(id string, key []byte) {
+	kr.mtx.Lock()
+	kr.keys[id] = key
+	kr.current = id
+	kr.mtx.Unlock()
+}
+
+func (kr *StaticKeyRing) CurrentKey() (string, []byte, error) {
+	kr.mtx.RLock()
+	defer kr.mtx.RUnlock()
+
+	key, found := kr.keys[kr.current]
+	if !found {
+		return "", nil, errors.New("current key not found: " + kr.current)
+	}
+
+	return kr.current, key, nil
+}
+
+func (kr *StaticKeyRing) Key(id string) ([]byte, error) {
+	kr.mtx.RLock()
+	defer kr.mtx.RUnlock()
+
+	key, found := kr.keys[id]
+	if !found {
+		return nil, errors.New("key not found: " + id)
+	}
+
+	return key, nil
+}
+
+func hasConfigOption(field reflect.StructField, option string) bool {
+	options := strings.Split(field.Tag.Get("config"), ",")
+	for _, o := range options[1:] {
+		if o == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encryptValue(kr KeyRing, plaintext string) (string, error) {
+	id, key, err := kr.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(id, ":") {
+		return "", errors.New("invalid key id: " + id)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+
+	return encryptedPrefix + id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func decryptValue(kr KeyRing, value string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("invalid encrypted value")
+	}
+
+	key, err := kr.Key(parts[0])
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// transformEncryptedFields calls f on every string field tagged as
+// encrypted.
+func transformEncryptedFields(rv reflect.Value, f func(string) (string, error)) error {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		fv := rv.Field(i)
+		if !hasConfigOption(field, "encrypted") {
+			if err := transformEncryptedFields(fv, f); err != nil {
+				return errors.Wrap(err, field.Name)
+			}
+			continue
+		}
+
+		if fv.Kind() != reflect.String {
+			return errors.New(field.Name + ": only string fields can be encrypted")
+		}
+
+		transformed, err := f(fv.String())
+		if err != nil {
+			return errors.Wrap(err, field.Name)
+		}
+		fv.SetString(transformed)
+	}
+
+	return nil
+}
+
+// encryptFields returns a copy of v with its encrypted fields encrypted.
+func encryptFields(kr KeyRing, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	ptr := reflect.New(reflect.TypeOf(v))
+	ptr.Elem().Set(deepCopy(reflect.ValueOf(v)))
+
+	err := transformEncryptedFields(ptr, func(s string) (string, error) {
+		if s == "" {
+			return s, nil
+		}
+		if kr == nil {
+			return "", errors.New("no key ring is configured for encrypted fields")
+		}
+		// a plaintext can look like an encrypted value
+		if strings.HasPrefix(s, encryptedPrefix) {
+			if _, err := decryptValue(kr, s); err == nil {
+				return s, nil
+			}
+		}
+		return encryptValue(kr, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ptr.Elem().Interface(), nil
+}
+
+// decryptFields decrypts the encrypted fields of v in place.
+//
+// Plain text values are left as is.
+func decryptFields(kr KeyRing, v interface{}) error {
+	return transformEncryptedFields(reflect.ValueOf(v), func(s string) (string, error) {
+		if !strings.HasPrefix(s, encryptedPrefix) {
+			return s, nil
+		}
+		if kr == nil {
+			return "", errors.New("no key ring is configured for encrypted fields")
+		}
+		return decryptValue(kr, s)
+	})
+}
+
+type encryptingProvider interface {
+	WritableProvider
+	reEncrypt(key string, t reflect.Type) error
+}
+
+// ReEncrypt rewrites the stored values of the given keys with the current
+// key of the key rings of the providers.
+//
+// Without keys, every key with a registered schema is rewritten in the
+// providers that can enumerate their keys.
+func (s *Store) ReEncrypt(namespace string, keys ...string) error {
+	collection := s.ensureNamespace(namespace)
+	if collection == nil {
+		return CollectionNotFoundError{namespace}
+	}
+
+	all := len(keys) == 0
+	if all {
+		var err error
+		if keys, err = collection.candidateKeys(); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		sc := s.schemas.Get(key)
+		if sc == nil {
+			if all {
+				continue
+			}
+			return errors.New("schema not found: " + key)
+		}
+
+		for _, provider := range collection.getProviders() {
+			ep, ok := provider.(encryptingProvider)
+			if !ok || !ep.Has(key) || !ep.CanSave(key) {
+				continue
+			}
+			if err := ep.reEncrypt(key, sc.(*schema).typ); err != nil {
+				return errors.Wrap(err, "failed to re-encrypt "+key)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+	"github.com/tamasd/constellation/util"
+)
+
+type encryptedTest struct {
+	User     string
+	Password string `config:",encrypted"`
+	Nested   struct {
+		Token string `config:",encrypted"`
+	}
+}
+
+func TestEncryptedFields(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	kr := config.NewStaticKeyRing("k1", map[string][]byte{
+		"k1": []byte("0123456789abcdef0123456789abcdef"),
+	})
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, false)
+	dp.RegisterFiletype(&config.JSON{})
+	dp.SetKeyRing(kr)
+	collection := config.NewCollection()
+	collection.AddProviders(dp)
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("credentials", reflect.TypeOf(encryptedTest{}))
+	c.AddCollection("config", collection)
+
+	value := encryptedTest{User: "admin", Password: "hunter2"}
+	value.Nested.Token = "t0ken"
+
+	readFile := func() string {
+		data, err := ioutil.ReadFile(filepath.Join(tmpdir, "credentials.json"))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("values are encrypted at rest", func(t *testing.T) {
+		_, saver, err := c.GetWritable("config").GetWritable("credentials")
+		require.NoError(t, err)
+		require.NoError(t, saver.Save(value))
+
+		content := readFile()
+		require.Contains(t, content, "admin")
+		require.NotContains(t, content, "hunter2")
+		require.NotContains(t, content, "t0ken")
+		require.Contains(t, content, "enc:v1:k1:")
+
+		collection.ClearCache()
+		v, err := c.Get("config").Get("credentials")
+		require.NoError(t, err)
+		require.Equal(t, value, v)
+	})
+
+	t.Run("plaintexts with the encrypted prefix are encrypted", func(t *testing.T) {
+		lookalike := value
+		lookalike.Password = "enc:v1:k1:hunter2"
+		_, saver, err := c.GetWritable("config").GetWritable("credentials")
+		require.NoError(t, err)
+		require.NoError(t, saver.Save(lookalike))
+		require.NotContains(t, readFile(), "hunter2")
+
+		collection.ClearCache()
+		v, err := c.Get("config").Get("credentials")
+		require.NoError(t, err)
+		require.Equal(t, lookalike, v)
+
+		_, saver, err = c.GetWritable("config").GetWritable("credentials")
+		require.NoError(t, err)
+		require.NoError(t, saver.Save(value))
+	})
+
+	t.Run("values are re-encrypted with the rotated key", func(t *testing.T) {
+		kr.Rotate("k2", []byte("fedcba9876543210"))
+		require.NoError(t, c.ReEncrypt("config", "credentials"))
+
+		content := readFile()
+		require.NotContains(t, content, "enc:v1:k1:")
+		require.Contains(t, content, "enc:v1:k2:")
+
+		collection.ClearCache()
+		v, err := c.Get("config").Get("credentials")
+		require.NoError(t, err)
+		require.Equal(t, value, v)
+	})
+
+	t.Run("values cannot be decrypted without the key", func(t *testing.T) {
+		dp.SetKeyRing(config.NewStaticKeyRing("k1", map[string][]byte{
+			"k1": []byte("0123456789abcdef0123456789abcdef"),
+		}))
+		collection.ClearCache()
+		_, err := c.Get("config").Get("credentials")
+		require.Error(t, err)
+
+		dp.SetKeyRing(nil)
+		_, err = c.Get("config").Get("credentials")
+		require.Error(t, err)
+	})
+}
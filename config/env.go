@@ -21,12 +21,17 @@ package config
 
 import (
 	"os"
+	"reflect"
 	"strings"
 
 	"github.com/tamasd/constellation/config/env"
 )
 
 var _ Provider = &EnvConfigProvider{}
+var _ Describer = &EnvConfigProvider{}
+var _ FieldDescriber = &EnvConfigProvider{}
+var _ KeyLister = &EnvConfigProvider{}
+var _ SchemaProvider = &EnvConfigProvider{}
 
 type EnvConfigProvider struct {
 	Prefix    string
@@ -66,11 +71,20 @@ func (e *EnvConfigProvider) prefixedKey(key string) string {
 	return e.Prefix + e.Separator + key
 }
 
-func (e *EnvConfigProvider) loader(key string) (string, bool) {
-	val, found := e.variables[e.prefixedKey(key)]
+func (e *EnvConfigProvider) lookup(name string) (string, bool) {
+	val, found := e.variables[name]
 	return val, found
 }
 
+func (e *EnvConfigProvider) names() []string {
+	names := make([]string, 0, len(e.variables))
+	for name := range e.variables {
+		names = append(names, name)
+	}
+
+	return names
+}
+
 func (e *EnvConfigProvider) Has(key string) bool {
 	e.maybeInitializeVariables()
 	key = e.prefixedKey(key)
@@ -83,12 +97,82 @@ func (e *EnvConfigProvider) Has(key string) bool {
 	return false
 }
 
+// Keys lists the keys of the variables with the prefix of the provider.
+//
+// Since the separator between the key and the field names is the same as
+// between the field names, the key is assumed to end at the first
+// separator, so keys containing the separator are not listed correctly.
+func (e *EnvConfigProvider) Keys() ([]string, error) {
+	e.maybeInitializeVariables()
+
+	prefix := ""
+	if e.Prefix != "" {
+		prefix = e.Prefix + e.Separator
+	}
+
+	found := make(map[string]bool)
+	var keys []string
+	for name := range e.variables {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		key := strings.ToLower(strings.SplitN(strings.TrimPrefix(name, prefix), e.Separator, 2)[0])
+		if key != "" && !found[key] {
+			found[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (e *EnvConfigProvider) Describe(key string) string {
+	return "env:" + e.prefixedKey(key)
+}
+
+// DescribeField returns the variable that a field is read from, following
+// the env tags of the type.
+func (e *EnvConfigProvider) DescribeField(key string, t reflect.Type, path string) string {
+	name, found := e.unmarshaler(key).Name(t, path)
+	if !found {
+		return e.Describe(key)
+	}
+
+	return "env:" + name
+}
+
+// HasSchema checks if any of the variables of a key is set, including the
+// ones renamed with env tags.
+func (e *EnvConfigProvider) HasSchema(key string, t reflect.Type) bool {
+	if e.Has(key) {
+		return true
+	}
+
+	for _, name := range e.unmarshaler(key).Names(t) {
+		if _, found := e.variables[name]; found {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (e *EnvConfigProvider) Unmarshal(key string, v interface{}) error {
 	e.maybeInitializeVariables()
+
+	return e.unmarshaler(key).Unmarshal(v)
+}
+
+// unmarshaler returns the unmarshaler of a key. The envDefault tags are
+// applied by the Store as the defaults of the schema, so the defaults do not
+// override the other providers.
+func (e *EnvConfigProvider) unmarshaler(key string) *env.Unmarshaler {
 	u := env.NewUnmarshaler()
-	u.Prefix = key
+	u.Prefix = e.prefixedKey(key)
 	u.Separator = e.Separator
-	u.Loader = e.loader
+	u.Loader = e.lookup
+	u.Lister = e.names
+	u.IgnoreDefaults = true
 
-	return u.Unmarshal(v)
+	return u
 }
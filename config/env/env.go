@@ -20,10 +20,13 @@
 package env
 
 import (
+	"encoding"
+	"fmt"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -45,29 +48,149 @@ func (e *InvalidUnmarshalError) Error() string {
 	return "env: Unmarshal(" + e.Type.String() + ")"
 }
 
+// MissingError is reported when a required variable is not set.
+type MissingError struct {
+	// Name is the full name of the variable.
+	Name string
+	// Field is the path of the target field, like Servers[0].Port.
+	Field string
+}
+
+func (e *MissingError) Error() string {
+	return "env: required variable " + e.Name + fieldSuffix(e.Field) + " is not set"
+}
+
+// FieldError is reported when the value of a variable cannot be parsed.
+type FieldError struct {
+	// Name is the full name of the variable.
+	Name string
+	// Value is the raw value of the variable.
+	Value string
+	// Field is the path of the target field, like Servers[0].Port.
+	Field string
+	// Redacted hides the value in the error message.
+	Redacted bool
+	Err      error
+}
+
+func (e *FieldError) Error() string {
+	value := strconv.Quote(e.Value)
+	cause := e.Err.Error()
+	if e.Redacted {
+		value = "[REDACTED]"
+		// parse errors often quote the value
+		if e.Value != "" {
+			cause = strings.ReplaceAll(cause, e.Value, value)
+		}
+	}
+
+	return "env: invalid value " + value + " of " + e.Name + fieldSuffix(e.Field) + ": " + cause
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+func fieldSuffix(field string) string {
+	if field == "" {
+		return ""
+	}
+
+	return " (" + field + ")"
+}
+
+// Errors is returned by Unmarshaler when variables are missing or invalid.
+// The items are *MissingError and *FieldError values, in the order of the
+// fields.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// As finds the first item that matches the target.
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unmarshaler fills structs from environment variables.
+//
+// The name of the variable of a field is the name of its parent and the
+// converted field name, joined with the separator. The following struct tags
+// are supported:
+//
+//	env:"NAME"            NAME is used instead of the converted field name
+//	env:"NAME,absolute"   NAME is the full name of the variable, without the prefix
+//	env:"-"               the field is skipped
+//	env:",required"       an error is returned if the variable is not set
+//	envDefault:"value"    the value of the field if the variable is not set
+//
+// The defaults are not applied to the fields of list items, since the items
+// only exist if they have variables.
 type Unmarshaler struct {
 	NameConverter func(string) string
 	Loader        func(string) (string, bool)
 	Prefix        string
 	Separator     string
 	Strict        bool
+	// Lister returns the names of all variables. It is used to find the
+	// keys of maps.
+	Lister func() []string
+	// ListSeparator separates the items of lists and maps in a single
+	// variable. Defaults to a comma.
+	ListSeparator string
+	// IgnoreDefaults disables the envDefault tags, for callers that apply
+	// the defaults themselves.
+	IgnoreDefaults bool
+	// RedactValues hides the values of the variables in the errors.
+	RedactValues bool
+}
+
+type fieldOptions struct {
+	required   bool
+	hasDefault bool
+	def        string
 }
 
 func NewUnmarshaler() *Unmarshaler {
 	return &Unmarshaler{
 		NameConverter: strings.ToLower,
 		Loader:        os.LookupEnv,
+		Lister:        environNames,
 		Separator:     "_",
 	}
 }
 
+func environNames() []string {
+	environ := os.Environ()
+	names := make([]string, len(environ))
+	for i, ev := range environ {
+		names[i] = strings.SplitN(ev, "=", 2)[0]
+	}
+
+	return names
+}
+
+// Unmarshal fills a value from the variables.
+//
+// Missing and invalid variables do not stop the unmarshaling: all of them are
+// returned in an Errors value.
 func (u *Unmarshaler) Unmarshal(v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			if rerr, ok := r.(error); ok {
-				err = rerr
-			} else if str, ok := r.(string); ok {
-				err = errors.New(str)
+			if ierr, ok := r.(*InvalidUnmarshalError); ok {
+				err = ierr
 			} else {
 				panic(r)
 			}
@@ -78,77 +201,570 @@ func (u *Unmarshaler) Unmarshal(v interface{}) (err error) {
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return &InvalidUnmarshalError{reflect.TypeOf(v), false}
 	}
-	u.unmarshal(u.Prefix, rv)
+
+	d := &decoder{
+		Unmarshaler: u,
+		visiting:    make(map[reflect.Type]int),
+	}
+	d.unmarshal(u.Prefix, "", rv, fieldOptions{})
+	if len(d.errs) > 0 {
+		return d.errs
+	}
 
 	return nil
 }
 
-func (u *Unmarshaler) unmarshal(current string, rv reflect.Value) {
+// decoder holds the state of an Unmarshal call.
+type decoder struct {
+	*Unmarshaler
+	errs Errors
+	// visiting holds the struct types that are being unmarshaled, to detect
+	// self-referential types.
+	visiting map[reflect.Type]int
+	// elements is the depth of the list items being unmarshaled. The items
+	// are only created by variables, not by defaults.
+	elements int
+}
+
+// unmarshal fills a value from the variables named after current, and
+// reports if any of the variables were set. The path is the field path of
+// the value in the errors.
+func (d *decoder) unmarshal(current, path string, rv reflect.Value, opts fieldOptions) bool {
 	current = strings.ToUpper(current)
+
+	if IsText(rv.Type()) {
+		val, found := d.lookup(current, path, opts)
+		if found {
+			d.parse(rv, current, path, val)
+		}
+		return found
+	}
+
 	switch rv.Kind() {
-	case reflect.Bool:
-		if val, found := u.Loader(current); found {
-			val = strings.ToLower(val)
-			switch val {
-			case "true":
-				rv.SetBool(true)
-			case "false":
-				rv.SetBool(false)
-			default:
-				panic("invalid value")
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int32, reflect.Int8, reflect.Int16, reflect.Int64,
+		reflect.Uint, reflect.Uint32, reflect.Uint8, reflect.Uint16, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		val, found := d.lookup(current, path, opts)
+		if found {
+			d.parse(rv, current, path, val)
+		}
+		return found
+	case reflect.Ptr:
+		if !rv.IsNil() {
+			return d.unmarshal(current, path, rv.Elem(), opts)
+		}
+		if !d.descend(current, rv.Type().Elem()) {
+			return false
+		}
+		ptr := reflect.New(rv.Type().Elem())
+		if d.unmarshal(current, path, ptr.Elem(), opts) {
+			rv.Set(ptr)
+			return true
+		}
+	case reflect.Slice:
+		return d.unmarshalSlice(current, path, rv, opts)
+	case reflect.Map:
+		return d.unmarshalMap(current, path, rv, opts)
+	case reflect.Struct:
+		found := false
+		structType := rv.Type()
+		d.visiting[structType]++
+		defer func() { d.visiting[structType]-- }()
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			childname, childOpts, skip := d.field(current, field)
+			if skip {
+				continue
+			}
+			if d.unmarshal(childname, fieldPath(path, field.Name), rv.Field(i), childOpts) {
+				found = true
 			}
 		}
-	case reflect.Int, reflect.Int32, reflect.Int8, reflect.Int16, reflect.Int64:
-		if val, found := u.Loader(current); found {
-			i, err := strconv.ParseInt(val, 0, 64)
-			if err != nil {
-				panic(err)
+		return found
+	default:
+		if d.Strict {
+			panic(&InvalidUnmarshalError{rv.Type(), true})
+		}
+	}
+
+	return false
+}
+
+// unmarshalSlice reads a list from a single variable, separated with the
+// list separator, or from indexed variables (NAME_0, NAME_1, ...).
+func (d *decoder) unmarshalSlice(current, path string, rv reflect.Value, opts fieldOptions) bool {
+	val, found := d.Loader(current)
+	if found {
+		d.parseList(rv, current, path, val)
+		return true
+	}
+
+	elemType := rv.Type().Elem()
+	slice := reflect.MakeSlice(rv.Type(), 0, 0)
+	d.elements++
+	for i := 0; ; i++ {
+		name := current + d.Separator + strconv.Itoa(i)
+		if (d.Lister != nil && !d.hasVariables(name)) || !d.descend(name, elemType) {
+			break
+		}
+		elem := reflect.New(elemType).Elem()
+		if !d.unmarshal(name, indexPath(path, strconv.Itoa(i)), elem, fieldOptions{}) {
+			break
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	d.elements--
+	if slice.Len() > 0 {
+		rv.Set(slice)
+		return true
+	}
+
+	if val, found = d.fallback(current, path, opts); found {
+		d.parseList(rv, current, path, val)
+	}
+
+	return found
+}
+
+func (d *decoder) parseList(rv reflect.Value, name, path, val string) {
+	if rv.Type().Elem().Kind() == reflect.Uint8 {
+		rv.SetBytes([]byte(val))
+		return
+	}
+
+	parts := d.split(val)
+	slice := reflect.MakeSlice(rv.Type(), len(parts), len(parts))
+	for i, part := range parts {
+		d.parse(slice.Index(i), name, indexPath(path, strconv.Itoa(i)), part)
+	}
+	rv.Set(slice)
+}
+
+// unmarshalMap reads a map from the variables named after the keys
+// (NAME_KEY), or from a single variable with a list of key=value pairs.
+//
+// The keys are the rest of the variable names, converted with the
+// NameConverter. If the values are structs, lists or maps, the key ends at
+// the first separator.
+func (d *decoder) unmarshalMap(current, path string, rv reflect.Value, opts fieldOptions) bool {
+	m := reflect.MakeMap(rv.Type())
+	found := false
+
+	val, set := d.Loader(current)
+	if set {
+		d.parsePairs(m, current, path, val)
+		found = true
+	}
+
+	if d.Lister != nil {
+		prefix := current + d.Separator
+		nested := isNested(rv.Type().Elem())
+		seen := make(map[string]bool)
+		for _, name := range d.Lister() {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+
+			rest := name[len(prefix):]
+			if nested {
+				rest = strings.SplitN(rest, d.Separator, 2)[0]
+			}
+			if rest == "" || seen[rest] {
+				continue
 			}
-			rv.SetInt(i)
+			seen[rest] = true
+
+			key, ok := d.mapKey(rv.Type().Key(), prefix+rest, path, rest)
+			if !ok {
+				continue
+			}
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if !d.unmarshal(prefix+rest, indexPath(path, fmt.Sprint(key.Interface())), elem, fieldOptions{}) {
+				continue
+			}
+			m.SetMapIndex(key, elem)
+			found = true
 		}
-	case reflect.Uint, reflect.Uint32, reflect.Uint8, reflect.Uint16, reflect.Uint64:
-		if val, found := u.Loader(current); found {
-			i, err := strconv.ParseUint(val, 0, 64)
-			if err != nil {
-				panic(err)
+	}
+
+	if !found {
+		if val, found = d.fallback(current, path, opts); found {
+			d.parsePairs(m, current, path, val)
+		}
+	}
+
+	if found {
+		if rv.IsNil() {
+			rv.Set(m)
+		} else {
+			iter := m.MapRange()
+			for iter.Next() {
+				rv.SetMapIndex(iter.Key(), iter.Value())
 			}
-			rv.SetUint(i)
 		}
+	}
+
+	return found
+}
+
+func (d *decoder) parsePairs(m reflect.Value, name, path, val string) {
+	for _, pair := range d.split(val) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			d.fail(name, path, val, errors.Errorf("invalid map entry %q", pair))
+			continue
+		}
+
+		k := strings.TrimSpace(kv[0])
+		key := reflect.New(m.Type().Key()).Elem()
+		if !d.parse(key, name, path, k) {
+			continue
+		}
+		elem := reflect.New(m.Type().Elem()).Elem()
+		if !d.parse(elem, name, indexPath(path, k), strings.TrimSpace(kv[1])) {
+			continue
+		}
+		m.SetMapIndex(key, elem)
+	}
+}
+
+func (d *decoder) mapKey(t reflect.Type, name, path, key string) (reflect.Value, bool) {
+	if d.NameConverter != nil {
+		key = d.NameConverter(key)
+	}
+
+	rv := reflect.New(t).Elem()
+	ok := d.parse(rv, name, path, key)
+
+	return rv, ok
+}
+
+func (u *Unmarshaler) split(val string) []string {
+	if val == "" {
+		return nil
+	}
+
+	separator := u.ListSeparator
+	if separator == "" {
+		separator = ","
+	}
+
+	parts := strings.Split(val, separator)
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+
+	return parts
+}
+
+// parse sets a scalar value, and records the error of an invalid value.
+func (d *decoder) parse(rv reflect.Value, name, path, val string) bool {
+	if err := Parse(rv, val); err != nil {
+		d.fail(name, path, val, err)
+		return false
+	}
+
+	return true
+}
+
+func (d *decoder) fail(name, path, val string, err error) {
+	d.errs = append(d.errs, &FieldError{
+		Name:     name,
+		Value:    val,
+		Field:    path,
+		Redacted: d.RedactValues,
+		Err:      err,
+	})
+}
+
+// Parse sets a scalar value from its string representation.
+//
+// Pointers are allocated, durations are parsed with time.ParseDuration, and
+// the types implementing encoding.TextUnmarshaler unmarshal themselves.
+// Booleans accept the values of strconv.ParseBool in any case, integers can
+// have a base prefix.
+func Parse(rv reflect.Value, val string) error {
+	if rv.Kind() == reflect.Ptr {
+		ptr := reflect.New(rv.Type().Elem())
+		if err := Parse(ptr.Elem(), val); err != nil {
+			return err
+		}
+		rv.Set(ptr)
+		return nil
+	}
+
+	if rv.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		rv.SetInt(int64(d))
+		return nil
+	}
+
+	if reflect.PtrTo(rv.Type()).Implements(textUnmarshalerType) {
+		return rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(val))
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.ToLower(val))
+		if err != nil {
+			return err
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int8, reflect.Int16, reflect.Int64:
+		i, err := strconv.ParseInt(val, 0, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint32, reflect.Uint8, reflect.Uint16, reflect.Uint64:
+		i, err := strconv.ParseUint(val, 0, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetUint(i)
 	case reflect.Float32, reflect.Float64:
-		if val, found := u.Loader(current); found {
-			f, err := strconv.ParseFloat(val, 64)
-			if err != nil {
-				panic(err)
-			}
-			rv.SetFloat(f)
+		f, err := strconv.ParseFloat(val, rv.Type().Bits())
+		if err != nil {
+			return err
 		}
-	case reflect.Ptr:
-		u.unmarshal(current, rv.Elem())
+		rv.SetFloat(f)
 	case reflect.String:
-		if val, found := u.Loader(current); found {
-			rv.SetString(val)
+		rv.SetString(val)
+	default:
+		return &InvalidUnmarshalError{rv.Type(), true}
+	}
+
+	return nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// IsText checks if a type is read from a single variable, even if it is not
+// a scalar.
+func IsText(t reflect.Type) bool {
+	return t == durationType || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(textUnmarshalerType))
+}
+
+// isNested checks if a type is read from multiple variables.
+func isNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if IsText(t) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		return true
+	}
+
+	return false
+}
+
+func fieldPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
+
+func indexPath(path, index string) string {
+	return path + "[" + index + "]"
+}
+
+// descend checks if a nested value of a type has to be unmarshaled. A
+// self-referential type is only unmarshaled again if a variable is set
+// under its name.
+func (d *decoder) descend(current string, t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if d.visiting[t] == 0 {
+		return true
+	}
+
+	return d.Lister != nil && d.hasVariables(current)
+}
+
+// hasVariables checks if a variable is set under a name, using the Lister.
+func (d *decoder) hasVariables(current string) bool {
+	current = strings.ToUpper(current)
+	for _, name := range d.Lister() {
+		if name == current || strings.HasPrefix(name, current+d.Separator) {
+			return true
 		}
-	case reflect.Struct:
-		structType := rv.Type()
-		for i := 0; i < structType.NumField(); i++ {
-			field := structType.Field(i)
-			childname := u.childName(current, field.Name)
-			u.unmarshal(childname, rv.Field(i))
+	}
+
+	return false
+}
+
+func (d *decoder) lookup(name, path string, opts fieldOptions) (string, bool) {
+	if val, found := d.Loader(name); found {
+		return val, true
+	}
+
+	return d.fallback(name, path, opts)
+}
+
+// fallback returns the default of an unset variable, and records the error
+// of a missing required variable.
+func (d *decoder) fallback(name, path string, opts fieldOptions) (string, bool) {
+	if opts.hasDefault && !d.IgnoreDefaults && d.elements == 0 {
+		return opts.def, true
+	}
+	if opts.required {
+		d.errs = append(d.errs, &MissingError{Name: name, Field: path})
+	}
+
+	return "", false
+}
+
+// field returns the variable name and the options of a struct field.
+func (u *Unmarshaler) field(current string, field reflect.StructField) (string, fieldOptions, bool) {
+	return fieldName(current, field, u.Separator, u.NameConverter)
+}
+
+// fieldName returns the variable name and the options of a struct field, or
+// true if the field is skipped.
+func fieldName(current string, field reflect.StructField, separator string, converter func(string) string) (string, fieldOptions, bool) {
+	if field.PkgPath != "" {
+		return "", fieldOptions{}, true
+	}
+
+	tag := field.Tag.Get("env")
+	if tag == "-" {
+		return "", fieldOptions{}, true
+	}
+
+	opts := fieldOptions{}
+	opts.def, opts.hasDefault = field.Tag.Lookup("envDefault")
+
+	parts := strings.Split(tag, ",")
+	absolute := false
+	for _, option := range parts[1:] {
+		switch strings.TrimSpace(option) {
+		case "required":
+			opts.required = true
+		case "absolute":
+			absolute = true
 		}
+	}
+
+	name := strings.TrimSpace(parts[0])
+	switch {
+	case name == "":
+		name = childName(current, field.Name, separator, converter)
+	case absolute:
+	case current == "":
 	default:
-		if u.Strict {
-			panic(&InvalidUnmarshalError{rv.Type(), true})
+		name = current + separator + name
+	}
+
+	return strings.ToUpper(name), opts, false
+}
+
+// Names returns the names of the variables that are read for a type.
+//
+// The fields of self-referential types are listed only once.
+func (u *Unmarshaler) Names(t reflect.Type) []string {
+	var names []string
+	u.names(strings.ToUpper(u.Prefix), t, make(map[reflect.Type]bool), &names)
+
+	return names
+}
+
+func (u *Unmarshaler) names(current string, t reflect.Type, visiting map[reflect.Type]bool, names *[]string) {
+	if IsText(t) {
+		*names = append(*names, current)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int32, reflect.Int8, reflect.Int16, reflect.Int64,
+		reflect.Uint, reflect.Uint32, reflect.Uint8, reflect.Uint16, reflect.Uint64,
+		reflect.Float32, reflect.Float64,
+		reflect.Slice, reflect.Map:
+		*names = append(*names, current)
+	case reflect.Ptr:
+		u.names(current, t.Elem(), visiting, names)
+	case reflect.Struct:
+		if visiting[t] {
+			return
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := 0; i < t.NumField(); i++ {
+			childname, _, skip := u.field(current, t.Field(i))
+			if !skip {
+				u.names(childname, t.Field(i).Type, visiting, names)
+			}
 		}
 	}
 }
 
-func (u *Unmarshaler) childName(current, child string) string {
-	if u.NameConverter != nil {
-		child = u.NameConverter(child)
+// Name returns the name of the variable that a field of a type is read from.
+// The path is in the format of the field paths of the errors, e.g.
+// "Servers[0].Port". Returns false if the path is not in the type.
+func (u *Unmarshaler) Name(t reflect.Type, path string) (string, bool) {
+	current := u.Prefix
+	for path != "" {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		switch path[0] {
+		case '.':
+			path = path[1:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array && t.Kind() != reflect.Map) {
+				return "", false
+			}
+			current = current + u.Separator + path[1:end]
+			t = t.Elem()
+			path = path[end+1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if t.Kind() != reflect.Struct {
+				return "", false
+			}
+			field, found := t.FieldByName(path[:end])
+			if !found || len(field.Index) != 1 {
+				return "", false
+			}
+			name, _, skip := u.field(current, field)
+			if skip {
+				return "", false
+			}
+			current = name
+			t = field.Type
+			path = path[end:]
+		}
+	}
+
+	return strings.ToUpper(current), true
+}
+
+func childName(current, child, separator string, converter func(string) string) string {
+	if converter != nil {
+		child = converter(child)
 	}
 	if current == "" {
 		return child
 	}
 
-	return current + u.Separator + child
+	return current + separator + child
 }
@@ -20,12 +20,15 @@
 package env_test
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/tamasd/constellation/config/env"
+	"github.com/tamasd/constellation/uuid"
 )
 
 type data struct {
@@ -111,3 +114,260 @@ func TestUnmarshaler_Unmarshal_InvalidType(t *testing.T) {
 	require.NotNil(t, err)
 	require.Equal(t, "env: Unmarshal(func())", err.Error())
 }
+
+type taggedData struct {
+	Host     string `env:"PGHOST,absolute"`
+	MaxConns int    `env:"MAX_CONNS" envDefault:"10"`
+	Skipped  string `env:"-"`
+	Name     string `env:",required"`
+	Nested   struct {
+		Port int `env:"PORT"`
+	} `env:"DB"`
+}
+
+func TestUnmarshaler_Unmarshal_Tags(t *testing.T) {
+	os.Clearenv()
+	for k, v := range map[string]string{
+		"PGHOST":          "localhost",
+		"FOO_SKIPPED":     "x",
+		"FOO_NAME":        "foo",
+		"FOO_DB_PORT":     "5432",
+		"FOO_MAXCONNS":    "1",
+		"FOO_HOST":        "example.com",
+		"FOO_NESTED_PORT": "1",
+	} {
+		require.Nil(t, os.Setenv(k, v))
+	}
+
+	u := env.NewUnmarshaler()
+	u.Prefix = "FOO"
+	v := taggedData{}
+	require.Nil(t, u.Unmarshal(&v))
+
+	expected := taggedData{
+		Host:     "localhost",
+		MaxConns: 10,
+		Name:     "foo",
+	}
+	expected.Nested.Port = 5432
+	require.Equal(t, expected, v)
+
+	require.Equal(t, []string{"PGHOST", "FOO_MAX_CONNS", "FOO_NAME", "FOO_DB_PORT"}, u.Names(reflect.TypeOf(v)))
+	for path, expected := range map[string]string{
+		"Host":        "PGHOST",
+		"MaxConns":    "FOO_MAX_CONNS",
+		"Nested.Port": "FOO_DB_PORT",
+	} {
+		name, found := u.Name(reflect.TypeOf(v), path)
+		require.True(t, found)
+		require.Equal(t, expected, name)
+	}
+	_, found := u.Name(reflect.TypeOf(v), "Skipped")
+	require.False(t, found)
+
+	require.Nil(t, os.Unsetenv("FOO_NAME"))
+	err := u.Unmarshal(&taggedData{})
+	require.Equal(t, env.Errors{&env.MissingError{Name: "FOO_NAME", Field: "Name"}}, err)
+}
+
+type server struct {
+	Host string
+	Port int
+}
+
+type complexData struct {
+	Hosts    []string
+	Ports    []int
+	Servers  []server
+	Labels   map[string]string
+	Limits   map[string]int
+	Backends map[string]server
+	Timeout  time.Duration
+	Started  time.Time
+	ID       uuid.UUID
+	Pool     *struct {
+		Size int
+	}
+	Unset *server
+}
+
+func TestUnmarshaler_Unmarshal_Complex(t *testing.T) {
+	os.Clearenv()
+	for k, v := range map[string]string{
+		"FOO_HOSTS":                 "a, b",
+		"FOO_PORTS_0":               "80",
+		"FOO_PORTS_1":               "443",
+		"FOO_SERVERS_0_HOST":        "x",
+		"FOO_SERVERS_1_HOST":        "y",
+		"FOO_SERVERS_1_PORT":        "8080",
+		"FOO_LABELS_TEAM":           "core",
+		"FOO_LABELS_COST_CENTER":    "r&d",
+		"FOO_LIMITS":                "cpu=2,memory=512",
+		"FOO_BACKENDS_PRIMARY_HOST": "db",
+		"FOO_TIMEOUT":               "1m30s",
+		"FOO_STARTED":               "2021-03-01T12:00:00Z",
+		"FOO_ID":                    "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"FOO_POOL_SIZE":             "5",
+	} {
+		require.Nil(t, os.Setenv(k, v))
+	}
+
+	u := env.NewUnmarshaler()
+	u.Prefix = "FOO"
+	u.Strict = true
+	v := complexData{}
+	require.Nil(t, u.Unmarshal(&v))
+
+	require.Equal(t, []string{"a", "b"}, v.Hosts)
+	require.Equal(t, []int{80, 443}, v.Ports)
+	require.Equal(t, []server{{Host: "x"}, {Host: "y", Port: 8080}}, v.Servers)
+	require.Equal(t, map[string]string{"team": "core", "cost_center": "r&d"}, v.Labels)
+	require.Equal(t, map[string]int{"cpu": 2, "memory": 512}, v.Limits)
+	require.Equal(t, map[string]server{"primary": {Host: "db"}}, v.Backends)
+	require.Equal(t, 90*time.Second, v.Timeout)
+	require.Equal(t, time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), v.Started)
+	require.Equal(t, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", v.ID.String())
+	require.NotNil(t, v.Pool)
+	require.Equal(t, 5, v.Pool.Size)
+	require.Nil(t, v.Unset)
+
+	for path, expected := range map[string]string{
+		"Servers[1].Port":        "FOO_SERVERS_1_PORT",
+		"Backends[primary].Host": "FOO_BACKENDS_PRIMARY_HOST",
+		"Pool.Size":              "FOO_POOL_SIZE",
+	} {
+		name, found := u.Name(reflect.TypeOf(v), path)
+		require.True(t, found)
+		require.Equal(t, expected, name)
+	}
+	_, found := u.Name(reflect.TypeOf(v), "Hosts.Size")
+	require.False(t, found)
+}
+
+func TestUnmarshaler_Unmarshal_Errors(t *testing.T) {
+	os.Clearenv()
+	for k, v := range map[string]string{
+		"FOO_MAX_CONNS":      "ten",
+		"FOO_DB_PORT":        "5432",
+		"FOO_PORTS":          "80,http",
+		"FOO_SERVERS_0_PORT": "x",
+		"FOO_LIMITS":         "cpu=2,memory",
+		"FOO_TIMEOUT":        "soon",
+	} {
+		require.Nil(t, os.Setenv(k, v))
+	}
+
+	u := env.NewUnmarshaler()
+	u.Prefix = "FOO"
+	err := u.Unmarshal(&taggedData{})
+	require.IsType(t, env.Errors{}, err)
+	require.Len(t, err, 2)
+	require.Equal(t, `env: invalid value "ten" of FOO_MAX_CONNS (MaxConns): strconv.ParseInt: parsing "ten": invalid syntax; `+
+		`env: required variable FOO_NAME (Name) is not set`, err.Error())
+
+	var missing *env.MissingError
+	require.True(t, errors.As(err, &missing))
+	require.Equal(t, "FOO_NAME", missing.Name)
+
+	err = u.Unmarshal(&complexData{})
+	require.NotNil(t, err)
+	fields := make(map[string]string)
+	for _, item := range err.(env.Errors) {
+		fieldErr := item.(*env.FieldError)
+		fields[fieldErr.Field] = fieldErr.Name
+	}
+	require.Equal(t, map[string]string{
+		"Ports[1]":        "FOO_PORTS",
+		"Servers[0].Port": "FOO_SERVERS_0_PORT",
+		"Limits":          "FOO_LIMITS",
+		"Timeout":         "FOO_TIMEOUT",
+	}, fields)
+
+	u.RedactValues = true
+	err = u.Unmarshal(&simpleData{A: 1})
+	require.Nil(t, err)
+	require.Nil(t, os.Setenv("FOO_A", "secret"))
+	err = u.Unmarshal(&simpleData{})
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "secret")
+	require.Contains(t, err.Error(), "FOO_A")
+}
+
+type node struct {
+	Value    int
+	Next     *node
+	Children []node
+}
+
+type defaultItem struct {
+	Host string
+	Port int `envDefault:"80"`
+}
+
+func TestUnmarshaler_Unmarshal_Recursive(t *testing.T) {
+	os.Clearenv()
+	for k, v := range map[string]string{
+		"FOO_VALUE":                 "1",
+		"FOO_NEXT_VALUE":            "2",
+		"FOO_CHILDREN_0_VALUE":      "3",
+		"FOO_CHILDREN_1_NEXT_VALUE": "4",
+	} {
+		require.Nil(t, os.Setenv(k, v))
+	}
+
+	u := env.NewUnmarshaler()
+	u.Prefix = "FOO"
+	v := node{}
+	require.Nil(t, u.Unmarshal(&v))
+	require.Equal(t, node{
+		Value: 1,
+		Next:  &node{Value: 2},
+		Children: []node{
+			{Value: 3},
+			{Next: &node{Value: 4}},
+		},
+	}, v)
+
+	require.Equal(t, []string{"FOO_VALUE", "FOO_CHILDREN"}, u.Names(reflect.TypeOf(v)))
+
+	m := env.NewMarshaler()
+	m.Prefix = "FOO"
+	require.Equal(t, []env.VariableDoc{
+		{Name: "FOO_VALUE", Type: "int"},
+	}, m.Document(reflect.TypeOf(v)))
+
+	items := struct {
+		Items []defaultItem
+	}{}
+	require.Nil(t, os.Setenv("FOO_ITEMS_0_HOST", "a"))
+	require.Nil(t, u.Unmarshal(&items))
+	require.Equal(t, []defaultItem{{Host: "a"}}, items.Items)
+}
+
+func TestParse(t *testing.T) {
+	entries := []struct {
+		value    string
+		expected interface{}
+	}{
+		{"TRUE", true},
+		{"1", true},
+		{"f", false},
+		{"0x10", 16},
+		{"1.5", 1.5},
+		{"1m", time.Minute},
+		{"text", "text"},
+	}
+
+	for _, entry := range entries {
+		rv := reflect.New(reflect.TypeOf(entry.expected)).Elem()
+		require.NoError(t, env.Parse(rv, entry.value), entry.value)
+		require.Equal(t, entry.expected, rv.Interface(), entry.value)
+	}
+
+	var ptr *int
+	require.NoError(t, env.Parse(reflect.ValueOf(&ptr).Elem(), "5"))
+	require.Equal(t, 5, *ptr)
+
+	var b bool
+	require.Error(t, env.Parse(reflect.ValueOf(&b).Elem(), "yes"))
+}
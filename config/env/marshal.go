@@ -0,0 +1,327 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package env
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UnsupportedTypeError is returned by Marshaler when a value cannot be
+// represented as environment variables.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "env: Marshal(" + e.Type.String() + ")"
+}
+
+// Variable is an environment variable rendered by Marshaler.
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// VariableDoc describes an environment variable read by Unmarshaler.
+//
+// List items and map keys are written as <N> and <KEY> in the name.
+type VariableDoc struct {
+	Name       string
+	Type       string
+	Default    string
+	HasDefault bool
+	Required   bool
+}
+
+// Marshaler renders values as environment variables, with the same naming
+// rules and struct tags as Unmarshaler.
+//
+// Lists of scalars are joined with the list separator, other lists are
+// written as indexed variables. Maps are written as one variable per key.
+// Nil pointers, maps and slices are skipped.
+type Marshaler struct {
+	NameConverter func(string) string
+	Prefix        string
+	Separator     string
+	ListSeparator string
+}
+
+func NewMarshaler() *Marshaler {
+	return &Marshaler{
+		NameConverter: strings.ToLower,
+		Separator:     "_",
+		ListSeparator: ",",
+	}
+}
+
+// Marshal returns the variables of a value, in the order of the struct
+// fields, with the map keys sorted.
+func (m *Marshaler) Marshal(v interface{}) ([]Variable, error) {
+	var vars []Variable
+	if err := m.marshal(strings.ToUpper(m.Prefix), reflect.ValueOf(v), &vars); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+func (m *Marshaler) marshal(current string, rv reflect.Value, vars *[]Variable) error {
+	if !rv.IsValid() {
+		return nil
+	}
+
+	if isTextMarshaler(rv) {
+		val, err := formatValue(rv)
+		if err != nil {
+			return errors.Wrap(err, current)
+		}
+		*vars = append(*vars, Variable{Name: current, Value: val})
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return m.marshal(current, rv.Elem(), vars)
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int32, reflect.Int8, reflect.Int16, reflect.Int64,
+		reflect.Uint, reflect.Uint32, reflect.Uint8, reflect.Uint16, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		val, err := formatValue(rv)
+		if err != nil {
+			return errors.Wrap(err, current)
+		}
+		*vars = append(*vars, Variable{Name: current, Value: val})
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 && rv.Kind() == reflect.Slice {
+			*vars = append(*vars, Variable{Name: current, Value: string(rv.Bytes())})
+			return nil
+		}
+
+		if !hasNestedItem(rv) {
+			items := make([]string, rv.Len())
+			for i := range items {
+				val, err := formatValue(rv.Index(i))
+				if err != nil {
+					return errors.Wrap(err, current)
+				}
+				items[i] = val
+			}
+			*vars = append(*vars, Variable{Name: current, Value: strings.Join(items, m.listSeparator())})
+			return nil
+		}
+
+		for i := 0; i < rv.Len(); i++ {
+			if err := m.marshal(m.child(current, strconv.Itoa(i)), rv.Index(i), vars); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]reflect.Value, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := formatValue(iter.Key())
+			if err != nil {
+				return errors.Wrap(err, current)
+			}
+			keys = append(keys, key)
+			values[key] = iter.Value()
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if err := m.marshal(m.child(current, key), values[key], vars); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, _, skip := fieldName(current, t.Field(i), m.Separator, m.NameConverter)
+			if skip {
+				continue
+			}
+			if err := m.marshal(name, rv.Field(i), vars); err != nil {
+				return err
+			}
+		}
+	default:
+		return &UnsupportedTypeError{rv.Type()}
+	}
+
+	return nil
+}
+
+// child returns the name of a list item or a map entry.
+func (m *Marshaler) child(current, child string) string {
+	return strings.ToUpper(childName(current, child, m.Separator, nil))
+}
+
+func (m *Marshaler) listSeparator() string {
+	if m.ListSeparator == "" {
+		return ","
+	}
+
+	return m.ListSeparator
+}
+
+// Document lists the variables that Unmarshaler reads for a type, with the
+// same prefix, separator and name converter as the marshaler. The fields of
+// self-referential types are listed only once.
+func (m *Marshaler) Document(t reflect.Type) []VariableDoc {
+	var docs []VariableDoc
+	m.document(strings.ToUpper(m.Prefix), t, fieldOptions{}, make(map[reflect.Type]bool), &docs)
+
+	return docs
+}
+
+func (m *Marshaler) document(current string, t reflect.Type, opts fieldOptions, visiting map[reflect.Type]bool, docs *[]VariableDoc) {
+	leaf := func() {
+		*docs = append(*docs, VariableDoc{
+			Name:       current,
+			Type:       t.String(),
+			Default:    opts.def,
+			HasDefault: opts.hasDefault,
+			Required:   opts.required,
+		})
+	}
+
+	if IsText(t) {
+		leaf()
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		m.document(current, t.Elem(), opts, visiting, docs)
+	case reflect.Slice:
+		if isNested(t.Elem()) {
+			m.document(m.child(current, "<N>"), t.Elem(), fieldOptions{}, visiting, docs)
+			return
+		}
+		leaf()
+	case reflect.Map:
+		m.document(m.child(current, "<KEY>"), t.Elem(), fieldOptions{}, visiting, docs)
+	case reflect.Struct:
+		if visiting[t] {
+			return
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := 0; i < t.NumField(); i++ {
+			name, childOpts, skip := fieldName(current, t.Field(i), m.Separator, m.NameConverter)
+			if !skip {
+				m.document(name, t.Field(i).Type, childOpts, visiting, docs)
+			}
+		}
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int32, reflect.Int8, reflect.Int16, reflect.Int64,
+		reflect.Uint, reflect.Uint32, reflect.Uint8, reflect.Uint16, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		leaf()
+	}
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func isTextMarshaler(rv reflect.Value) bool {
+	if rv.Type() == durationType {
+		return true
+	}
+	if rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		return false
+	}
+
+	return rv.Type().Implements(textMarshalerType) ||
+		(rv.CanAddr() && reflect.PtrTo(rv.Type()).Implements(textMarshalerType))
+}
+
+// hasNestedItem checks if any item of a list is read from multiple
+// variables.
+func hasNestedItem(rv reflect.Value) bool {
+	for i := 0; i < rv.Len(); i++ {
+		item := rv.Index(i)
+		for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+			if item.IsNil() {
+				break
+			}
+			item = item.Elem()
+		}
+		if isNested(item.Type()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatValue returns the string representation of a scalar value, in the
+// format that Unmarshaler parses.
+func formatValue(rv reflect.Value) (string, error) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return "", nil
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Type() == durationType {
+		return fmt.Sprint(rv.Interface()), nil
+	}
+
+	if isTextMarshaler(rv) {
+		var tm encoding.TextMarshaler
+		if rv.Type().Implements(textMarshalerType) {
+			tm = rv.Interface().(encoding.TextMarshaler)
+		} else {
+			tm = rv.Addr().Interface().(encoding.TextMarshaler)
+		}
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int32, reflect.Int8, reflect.Int16, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint32, reflect.Uint8, reflect.Uint16, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits()), nil
+	case reflect.String:
+		return rv.String(), nil
+	}
+
+	return "", &UnsupportedTypeError{rv.Type()}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package env_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config/env"
+	"github.com/tamasd/constellation/uuid"
+)
+
+func TestMarshaler_Marshal(t *testing.T) {
+	id, err := uuid.FromString("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	require.Nil(t, err)
+
+	v := complexData{
+		Hosts:    []string{"a", "b"},
+		Ports:    []int{80, 443},
+		Servers:  []server{{Host: "x"}, {Host: "y", Port: 8080}},
+		Labels:   map[string]string{"team": "core", "cost_center": "r&d"},
+		Backends: map[string]server{"primary": {Host: "db"}},
+		Timeout:  90 * time.Second,
+		Started:  time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+		ID:       id,
+	}
+
+	m := env.NewMarshaler()
+	m.Prefix = "FOO"
+	vars, err := m.Marshal(v)
+	require.Nil(t, err)
+	require.Equal(t, []env.Variable{
+		{Name: "FOO_HOSTS", Value: "a,b"},
+		{Name: "FOO_PORTS", Value: "80,443"},
+		{Name: "FOO_SERVERS_0_HOST", Value: "x"},
+		{Name: "FOO_SERVERS_0_PORT", Value: "0"},
+		{Name: "FOO_SERVERS_1_HOST", Value: "y"},
+		{Name: "FOO_SERVERS_1_PORT", Value: "8080"},
+		{Name: "FOO_LABELS_COST_CENTER", Value: "r&d"},
+		{Name: "FOO_LABELS_TEAM", Value: "core"},
+		{Name: "FOO_BACKENDS_PRIMARY_HOST", Value: "db"},
+		{Name: "FOO_BACKENDS_PRIMARY_PORT", Value: "0"},
+		{Name: "FOO_TIMEOUT", Value: "1m30s"},
+		{Name: "FOO_STARTED", Value: "2021-03-01T12:00:00Z"},
+		{Name: "FOO_ID", Value: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+	}, vars)
+
+	values := make(map[string]string)
+	for _, variable := range vars {
+		values[variable.Name] = variable.Value
+	}
+	u := env.NewUnmarshaler()
+	u.Prefix = "FOO"
+	u.Loader = func(name string) (string, bool) {
+		value, found := values[name]
+		return value, found
+	}
+	u.Lister = func() []string {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		return names
+	}
+	unmarshaled := complexData{}
+	require.Nil(t, u.Unmarshal(&unmarshaled))
+	require.Equal(t, v.Hosts, unmarshaled.Hosts)
+	require.Equal(t, v.Servers, unmarshaled.Servers)
+	require.Equal(t, v.Labels, unmarshaled.Labels)
+	require.Equal(t, v.Backends, unmarshaled.Backends)
+	require.Equal(t, v.Timeout, unmarshaled.Timeout)
+	require.Equal(t, v.ID, unmarshaled.ID)
+}
+
+func TestMarshaler_Marshal_Tags(t *testing.T) {
+	v := taggedData{
+		Host:     "localhost",
+		MaxConns: 10,
+		Skipped:  "x",
+		Name:     "foo",
+	}
+	v.Nested.Port = 5432
+
+	m := env.NewMarshaler()
+	m.Prefix = "FOO"
+	vars, err := m.Marshal(&v)
+	require.Nil(t, err)
+	require.Equal(t, []env.Variable{
+		{Name: "PGHOST", Value: "localhost"},
+		{Name: "FOO_MAX_CONNS", Value: "10"},
+		{Name: "FOO_NAME", Value: "foo"},
+		{Name: "FOO_DB_PORT", Value: "5432"},
+	}, vars)
+}
+
+func TestMarshaler_Document(t *testing.T) {
+	m := env.NewMarshaler()
+	m.Prefix = "FOO"
+
+	require.Equal(t, []env.VariableDoc{
+		{Name: "PGHOST", Type: "string"},
+		{Name: "FOO_MAX_CONNS", Type: "int", Default: "10", HasDefault: true},
+		{Name: "FOO_NAME", Type: "string", Required: true},
+		{Name: "FOO_DB_PORT", Type: "int"},
+	}, m.Document(reflect.TypeOf(taggedData{})))
+
+	docs := m.Document(reflect.TypeOf(complexData{}))
+	names := make([]string, len(docs))
+	for i, doc := range docs {
+		names[i] = doc.Name
+	}
+	require.Equal(t, []string{
+		"FOO_HOSTS",
+		"FOO_PORTS",
+		"FOO_SERVERS_<N>_HOST",
+		"FOO_SERVERS_<N>_PORT",
+		"FOO_LABELS_<KEY>",
+		"FOO_LIMITS_<KEY>",
+		"FOO_BACKENDS_<KEY>_HOST",
+		"FOO_BACKENDS_<KEY>_PORT",
+		"FOO_TIMEOUT",
+		"FOO_STARTED",
+		"FOO_ID",
+		"FOO_POOL_SIZE",
+		"FOO_UNSET_HOST",
+		"FOO_UNSET_PORT",
+	}, names)
+	require.Equal(t, "time.Duration", docs[8].Type)
+}
+
+func TestMarshaler_Marshal_InvalidType(t *testing.T) {
+	_, err := env.NewMarshaler().Marshal(invalidData{F: func() {}})
+	require.NotNil(t, err)
+	require.Equal(t, "env: Marshal(func())", err.Error())
+
+	_, err = env.NewMarshaler().Marshal(map[[1]int]string{{1}: "a"})
+	require.IsType(t, &env.UnsupportedTypeError{}, errors.Cause(err))
+	require.Equal(t, "env: Marshal([1]int)", errors.Cause(err).Error())
+}
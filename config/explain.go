@@ -0,0 +1,118 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"encoding"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/config/validate"
+)
+
+// FieldExplanation describes the effective value of a field, and the
+// provider that supplied it.
+//
+// The values of resolved secrets are redacted.
+type FieldExplanation struct {
+	Path   string
+	Value  interface{}
+	Source string
+	Secret bool
+}
+
+// Explain lists the effective value of every field of a key, along with the
+// provider that supplied it.
+//
+// The values are loaded from the providers, bypassing the cache.
+func (s *Store) Explain(namespace, key string) ([]FieldExplanation, error) {
+	collection := s.ensureNamespace(namespace)
+	if collection == nil {
+		return nil, CollectionNotFoundError{namespace}
+	}
+
+	sc := s.schemas.Get(key)
+	if sc == nil {
+		return nil, errors.New("schema not found")
+	}
+
+	res, err := collection.resolve(key, sc.(*schema))
+	if err != nil {
+		return nil, withNamespace(err, namespace)
+	}
+	if res == nil {
+		return nil, nil
+	}
+
+	var explanations []FieldExplanation
+	walkLeaves("", res.value.Elem(), func(path string, v reflect.Value) {
+		explanation := FieldExplanation{
+			Path:   path,
+			Value:  v.Interface(),
+			Source: fieldSource(res.layers, key, path),
+		}
+		if containsSecret(res.secrets, path) {
+			explanation.Value = Redacted
+			explanation.Secret = true
+		}
+		explanations = append(explanations, explanation)
+	})
+
+	return explanations, nil
+}
+
+// walkLeaves calls f for every field of a struct that is not a struct.
+// Structs that marshal to text or have no exported fields, like time.Time,
+// are leaves as well.
+func walkLeaves(path string, rv reflect.Value, f func(path string, v reflect.Value)) {
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct || isLeafStruct(rv.Type()) {
+		f(path, rv)
+		return
+	}
+
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		walkLeaves(validate.JoinPath(path, field.Name), rv.Field(i), f)
+	}
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func isLeafStruct(t reflect.Type) bool {
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return true
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+	"github.com/tamasd/constellation/util"
+)
+
+func TestExplain(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test.*", reflect.TypeOf(test{}))
+
+	require.NoError(t, os.Setenv("EXPLAIN_TEST.1_B", "qwer"))
+	defer func() { _ = os.Unsetenv("EXPLAIN_TEST.1_B") }()
+
+	ep := config.NewEnvConfigProvider()
+	ep.Prefix = "EXPLAIN"
+	ep.Reset()
+	dp := config.NewDirectoryConfigProvider("fixtures/config", true)
+	registerFileTypes(dp)
+	collection := config.NewCollection()
+	collection.AddProviders(ep, dp)
+	c.AddCollection("config", collection)
+
+	explanations, err := c.Explain("config", "test.1")
+	require.NoError(t, err)
+
+	file := "file:fixtures/config/test.1.json"
+	require.Equal(t, []config.FieldExplanation{
+		{Path: "A", Value: 5, Source: file},
+		{Path: "B", Value: "qwer", Source: "env:EXPLAIN_TEST.1_B"},
+		{Path: "C", Value: true, Source: file},
+		{Path: "D.E", Value: -2, Source: file},
+		{Path: "D.F", Value: -1.2, Source: file},
+		{Path: "G", Value: "", Source: ""},
+	}, explanations)
+
+	_, err = c.Explain("config", "unknown")
+	require.Error(t, err)
+}
+
+func TestExplainEnvTags(t *testing.T) {
+	type tagged struct {
+		Host string `env:"EXPLAIN_PGHOST,absolute"`
+		Port int    `env:"DB_PORT"`
+		Pool struct {
+			Size int
+		} `env:"POOL"`
+	}
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("tagged", reflect.TypeOf(tagged{}))
+
+	for name, value := range map[string]string{
+		"EXPLAIN_PGHOST":           "localhost",
+		"EXPLAIN_TAGGED_DB_PORT":   "5432",
+		"EXPLAIN_TAGGED_POOL_SIZE": "5",
+	} {
+		require.NoError(t, os.Setenv(name, value))
+		defer func(name string) { _ = os.Unsetenv(name) }(name)
+	}
+
+	ep := config.NewEnvConfigProvider()
+	ep.Prefix = "EXPLAIN"
+	ep.Reset()
+	collection := config.NewCollection()
+	collection.AddProviders(ep)
+	c.AddCollection("config", collection)
+
+	explanations, err := c.Explain("config", "tagged")
+	require.NoError(t, err)
+	sources := make(map[string]string)
+	for _, e := range explanations {
+		sources[e.Path] = e.Source
+	}
+	require.Equal(t, "env:EXPLAIN_PGHOST", sources["Host"])
+	require.Equal(t, "env:EXPLAIN_TAGGED_DB_PORT", sources["Port"])
+	require.Equal(t, "env:EXPLAIN_TAGGED_POOL_SIZE", sources["Pool.Size"])
+}
+
+type timestamped struct {
+	Name    string
+	Created time.Time
+}
+
+func TestExplainLeafStructs(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("timestamped", reflect.TypeOf(timestamped{}))
+
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	created := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	dp := config.NewDirectoryConfigProvider(tmpdir, false)
+	dp.RegisterFiletype(&config.JSON{})
+	require.NoError(t, dp.Save("timestamped", timestamped{Name: "x", Created: created}))
+	collection := config.NewCollection()
+	collection.AddProviders(dp)
+	c.AddCollection("config", collection)
+
+	explanations, err := c.Explain("config", "timestamped")
+	require.NoError(t, err)
+	require.Len(t, explanations, 2)
+	require.Equal(t, "Created", explanations[1].Path)
+	require.True(t, created.Equal(explanations[1].Value.(time.Time)))
+	require.Equal(t, "file:"+filepath.Join(tmpdir, "timestamped.json"), explanations[1].Source)
+}
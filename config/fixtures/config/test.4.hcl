@@ -0,0 +1,11 @@
+# comment
+a = 5
+b = "asdf" // comment
+c = true
+
+/* block
+   comment */
+d {
+  e = -2
+  f = -1.2
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"flag"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/config/env"
+)
+
+var _ Provider = &FlagConfigProvider{}
+var _ FieldDescriber = &FlagConfigProvider{}
+var _ overridingProvider = &FlagConfigProvider{}
+
+// FlagConfigProvider reads config values from command line flags.
+//
+// Every field of a registered schema gets a flag named
+// --<key>.<field>.<subfield>, with the help text coming from the `help`
+// struct tag. Add it with Store.AddOverrides, so the flags override the
+// providers of every collection, including the ones built by collection
+// loaders.
+//
+// The flags that are set override the other layers even with zero values, so
+// --db.debug=false turns off a debug flag enabled in a file.
+type FlagConfigProvider struct {
+	NameConverter func(string) string
+	flags         *flag.FlagSet
+	mtx           sync.RWMutex
+	values        map[string][]*flagValue
+}
+
+func NewFlagConfigProvider(flags *flag.FlagSet) *FlagConfigProvider {
+	return &FlagConfigProvider{
+		NameConverter: strings.ToLower,
+		flags:         flags,
+		values:        make(map[string][]*flagValue),
+	}
+}
+
+// RegisterSchemas defines the flags of every schema in a store that does
+// not contain wildcards.
+func (p *FlagConfigProvider) RegisterSchemas(s *Store) error {
+	schemas := s.Schemas()
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		if !strings.Contains(name, "*") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := p.Register(name, schemas[name]); err != nil {
+			return errors.Wrap(err, "failed to register the flags of "+name)
+		}
+	}
+
+	return nil
+}
+
+// Register defines the flags of a schema. If one of the flags is already
+// defined, none of them are.
+func (p *FlagConfigProvider) Register(key string, t reflect.Type) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var flags []schemaFlag
+	p.register(key, "", nil, t, "", make(map[reflect.Type]bool), &flags)
+
+	defined := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		if defined[f.name] || p.flags.Lookup(f.name) != nil {
+			return errors.Errorf("flag %s is already defined", f.name)
+		}
+		defined[f.name] = true
+	}
+
+	for _, f := range flags {
+		p.flags.Var(f.value, f.name, f.help)
+		p.values[key] = append(p.values[key], f.value)
+	}
+
+	return nil
+}
+
+type schemaFlag struct {
+	name  string
+	help  string
+	value *flagValue
+}
+
+// register collects the flags of a type. The struct types in visiting are
+// not entered again, so self-referential types terminate.
+func (p *FlagConfigProvider) register(key, path string, index []int, t reflect.Type, help string, visiting map[reflect.Type]bool, flags *[]schemaFlag) {
+	if isFlagLeaf(t) {
+		name := key
+		if path != "" {
+			name += "." + p.convertPath(path)
+		}
+		*flags = append(*flags, schemaFlag{
+			name: name,
+			help: help,
+			value: &flagValue{
+				path:  path,
+				index: index,
+				typ:   t,
+			},
+		})
+		return
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+		p.register(key, fieldPath, fieldIndex, field.Type, field.Tag.Get("help"), visiting, flags)
+	}
+}
+
+func (p *FlagConfigProvider) convertPath(path string) string {
+	if p.NameConverter == nil {
+		return path
+	}
+
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		parts[i] = p.NameConverter(part)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func isFlagLeaf(t reflect.Type) bool {
+	if env.IsText(t) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return isFlagLeaf(t.Elem()) && t.Elem().Kind() != reflect.Slice
+	case reflect.Ptr:
+		return t.Elem().Kind() != reflect.Struct && isFlagLeaf(t.Elem())
+	}
+
+	return false
+}
+
+func (p *FlagConfigProvider) Has(key string) bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, v := range p.values[key] {
+		if v.set {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *FlagConfigProvider) Unmarshal(key string, v interface{}) error {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	rv := reflect.ValueOf(v).Elem()
+	for _, fv := range p.values[key] {
+		if !fv.set {
+			continue
+		}
+
+		target := rv
+		for _, i := range fv.index {
+			for target.Kind() == reflect.Ptr {
+				if target.IsNil() {
+					target.Set(reflect.New(target.Type().Elem()))
+				}
+				target = target.Elem()
+			}
+			target = target.Field(i)
+		}
+		target.Set(fv.value)
+	}
+
+	return nil
+}
+
+func (p *FlagConfigProvider) isSet(key, path string) bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, fv := range p.values[key] {
+		if fv.set && fv.path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *FlagConfigProvider) Describe(key string) string {
+	return "flag:--" + key
+}
+
+func (p *FlagConfigProvider) DescribeField(key string, t reflect.Type, path string) string {
+	return "flag:--" + key + "." + p.convertPath(path)
+}
+
+type flagValue struct {
+	path  string
+	index []int
+	typ   reflect.Type
+	raw   string
+	value reflect.Value
+	set   bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+
+	return v.raw
+}
+
+func (v *flagValue) Set(s string) error {
+	value := reflect.New(v.typ).Elem()
+	if err := parseString(value, s); err != nil {
+		return err
+	}
+
+	v.raw = s
+	v.value = value
+	v.set = true
+
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.typ.Kind() == reflect.Bool
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+)
+
+type flagTest struct {
+	Host    string `help:"database host"`
+	Port    int
+	Debug   bool
+	Timeout time.Duration
+	Tags    []string
+	Pool    *struct {
+		Max int `help:"maximum number of connections"`
+	}
+}
+
+func TestFlagConfigProvider(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("db", reflect.TypeOf(flagTest{}))
+	c.RegisterSchema("test.*", reflect.TypeOf(test{}))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(bytes.NewBuffer(nil))
+	fp := config.NewFlagConfigProvider(fs)
+	require.NoError(t, fp.RegisterSchemas(c))
+
+	require.NotNil(t, fs.Lookup("db.host"))
+	require.Equal(t, "database host", fs.Lookup("db.host").Usage)
+	require.Equal(t, "maximum number of connections", fs.Lookup("db.pool.max").Usage)
+	require.Nil(t, fs.Lookup("test.*.a"))
+
+	err := fs.Parse([]string{
+		"--db.host", "localhost",
+		"--db.debug",
+		"--db.timeout=5s",
+		"--db.tags", "a,b",
+		"--db.pool.max", "10",
+	})
+	require.NoError(t, err)
+
+	mp := config.NewMemoryConfigProvider()
+	require.NoError(t, mp.Save("db", flagTest{Host: "db.internal", Port: 5432}))
+	collection := config.NewCollection()
+	collection.AddProviders(fp, mp)
+	c.AddCollection("config", collection)
+
+	v, err := c.Get("config").Get("db")
+	require.NoError(t, err)
+	ft := v.(flagTest)
+	require.Equal(t, "localhost", ft.Host)
+	require.Equal(t, 5432, ft.Port)
+	require.True(t, ft.Debug)
+	require.Equal(t, 5*time.Second, ft.Timeout)
+	require.Equal(t, []string{"a", "b"}, ft.Tags)
+	require.NotNil(t, ft.Pool)
+	require.Equal(t, 10, ft.Pool.Max)
+
+	explanations, err := c.Explain("config", "db")
+	require.NoError(t, err)
+	require.Equal(t, "flag:--db.host", explanations[0].Source)
+
+	t.Run("flags are defined once", func(t *testing.T) {
+		type node struct {
+			Name string
+			Next *node
+		}
+
+		c := config.NewStore(null.NewLogger())
+		c.RegisterSchema("node", reflect.TypeOf(node{}))
+		c.RegisterSchema("node.name", reflect.TypeOf(""))
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fp := config.NewFlagConfigProvider(fs)
+		require.Error(t, fp.RegisterSchemas(c))
+		require.NotNil(t, fs.Lookup("node.name"))
+		require.Nil(t, fs.Lookup("node.next.name"))
+		require.Error(t, fp.Register("node", reflect.TypeOf(node{})))
+	})
+
+	t.Run("invalid values are rejected while parsing", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(bytes.NewBuffer(nil))
+		fp := config.NewFlagConfigProvider(fs)
+		require.NoError(t, fp.Register("db", reflect.TypeOf(flagTest{})))
+		require.Error(t, fs.Parse([]string{"--db.port", "asdf"}))
+		require.False(t, fp.Has("db"))
+	})
+
+	t.Run("overrides apply to loaded collections", func(t *testing.T) {
+		c := config.NewStore(null.NewLogger())
+		c.RegisterSchema("db", reflect.TypeOf(flagTest{}))
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(bytes.NewBuffer(nil))
+		fp := config.NewFlagConfigProvider(fs)
+		require.NoError(t, fp.RegisterSchemas(c))
+		require.NoError(t, fs.Parse([]string{"--db.debug=false", "--db.port", "0"}))
+		c.AddOverrides(fp)
+
+		c.AddCollectionLoaders(config.CollectionLoaderFunc(func(name string) (*config.Collection, error) {
+			mp := config.NewMemoryConfigProvider()
+			if err := mp.Save("db", flagTest{Host: "db.internal", Port: 5432, Debug: true}); err != nil {
+				return nil, err
+			}
+			collection := config.NewCollection()
+			collection.AddProviders(mp)
+			return collection, nil
+		}))
+
+		v, err := c.Get("loaded").Get("db")
+		require.NoError(t, err)
+		ft := v.(flagTest)
+		require.Equal(t, "db.internal", ft.Host)
+		require.Equal(t, 0, ft.Port)
+		require.False(t, ft.Debug)
+
+		explanations, err := c.Explain("loaded", "db")
+		require.NoError(t, err)
+		sources := make(map[string]string)
+		for _, e := range explanations {
+			sources[e.Path] = e.Source
+		}
+		require.Equal(t, "flag:--db.debug", sources["Debug"])
+		require.Equal(t, "memory:db", sources["Host"])
+	})
+
+}
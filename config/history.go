@@ -0,0 +1,149 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/util"
+)
+
+type contextKey int
+
+const (
+	actorContextKey contextKey = iota
+	reasonContextKey
+)
+
+// WithActor sets the actor of the changes saved with the context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// WithReason sets the reason of the changes saved with the context.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonContextKey, reason)
+}
+
+func actorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey).(string)
+	return actor
+}
+
+func reasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonContextKey).(string)
+	return reason
+}
+
+// ContextSaver is a Saver that passes the actor and the reason of the change
+// to the provider.
+//
+// The savers returned by WritableConfig.GetWritable implement this interface.
+type ContextSaver interface {
+	Saver
+	SaveContext(ctx context.Context, v interface{}) error
+}
+
+// ContextRevisionedProvider is implemented by providers that record the
+// actor and the reason of the changes.
+type ContextRevisionedProvider interface {
+	RevisionedProvider
+	SaveRevisionContext(ctx context.Context, key string, v interface{}, revision string) error
+}
+
+// HistoryEntry is a recorded change of a database config value.
+//
+// The values are stored as JSON, encrypted fields are not decrypted.
+type HistoryEntry struct {
+	Revision int64
+	OldValue json.RawMessage
+	NewValue json.RawMessage
+	Actor    string
+	Reason   string
+	Created  time.Time
+}
+
+// History returns the recorded changes of a key, oldest first.
+func (d *Database) History(namespace, key string) ([]HistoryEntry, error) {
+	rows, err := d.conn.Query(`
+		SELECT revision, old_value::text, new_value::text, actor, reason, created
+			FROM config_history
+			WHERE namespace = $1 AND name = $2
+			ORDER BY id
+	`, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	defer util.MustClose(rows)
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var oldValue sql.NullString
+		var newValue string
+		if err = rows.Scan(&entry.Revision, &oldValue, &newValue, &entry.Actor, &entry.Reason, &entry.Created); err != nil {
+			return nil, err
+		}
+		if oldValue.Valid {
+			entry.OldValue = json.RawMessage(oldValue.String)
+		}
+		entry.NewValue = json.RawMessage(newValue)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Rollback saves the value of a key that was stored at the given revision.
+// The loaded collection of the namespace is notified about the change.
+//
+// The rollback is a new change in the history. The actor and the reason are
+// taken from the context; the reason defaults to the rolled back revision.
+func (d *Database) Rollback(ctx context.Context, namespace, key string, revision int64) error {
+	if d.readOnly {
+		return errors.New("config database is read only")
+	}
+
+	var value string
+	err := d.conn.QueryRow(`
+		SELECT new_value::text
+			FROM config_history
+			WHERE namespace = $1 AND name = $2 AND revision = $3
+			ORDER BY id DESC
+			LIMIT 1
+	`, namespace, key, revision).Scan(&value)
+	if err == sql.ErrNoRows {
+		return errors.Errorf("revision %d of config %s/%s not found", revision, namespace, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	if reasonFromContext(ctx) == "" {
+		ctx = WithReason(ctx, "rollback to revision "+strconv.FormatInt(revision, 10))
+	}
+
+	return d.provider(namespace).saveJSON(ctx, key, value, nil)
+}
@@ -0,0 +1,544 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tamasd/constellation/util"
+)
+
+var _ CollectionLoader = &HTTP{}
+
+// HTTP loads the collections from a config service, see HTTPConfigProvider.
+type HTTP struct {
+	base        string
+	readOnly    bool
+	snapshotDir string
+	client      *http.Client
+}
+
+func NewHTTP(base string, readOnly bool) *HTTP {
+	return &HTTP{
+		base:     base,
+		readOnly: readOnly,
+	}
+}
+
+// SetSnapshotDir sets the directory of the last known good values.
+func (h *HTTP) SetSnapshotDir(dir string) {
+	h.snapshotDir = dir
+}
+
+// SetClient sets the HTTP client of the loaded collections.
+func (h *HTTP) SetClient(client *http.Client) {
+	h.client = client
+}
+
+func (h *HTTP) Load(name string) (*Collection, error) {
+	p := NewHTTPConfigProvider(h.base, name, h.readOnly)
+	p.RegisterFiletype(&JSON{})
+	p.RegisterFiletype(&YAML{})
+	p.RegisterFiletype(&TOML{})
+	p.RegisterFiletype(&XML{})
+	if h.snapshotDir != "" {
+		p.SetSnapshotDir(filepath.Join(h.snapshotDir, name))
+	}
+	if h.client != nil {
+		p.Client = h.client
+	}
+
+	c := NewCollection()
+	c.SetTemporary(true)
+	c.AddProviders(p)
+
+	return c, nil
+}
+
+var _ RevisionedProvider = &HTTPConfigProvider{}
+var _ ChangeNotifier = &HTTPConfigProvider{}
+var _ Poller = &HTTPConfigProvider{}
+var _ Describer = &HTTPConfigProvider{}
+var _ CacheTTLProvider = &HTTPConfigProvider{}
+
+// HTTPConfigProvider loads the values of a namespace from
+// "<base>/<namespace>/<key>", and saves them with PUT requests.
+//
+// The registered file types are offered in the Accept header, in order of
+// registration, and the response is decoded according to its Content-Type.
+// Responses are cached according to their Cache-Control header, and
+// revalidated with their ETag. The max-age of a response is also the time the
+// collection caches the value for; values without one are only reloaded when
+// Poll finds them changed. Has and the Unmarshal following it share one
+// request. When the server is not reachable, or responds
+// with a server error, the last known good value is used, which is also
+// written to the snapshot directory if there is one.
+//
+// The revisions of the values are their ETags.
+type HTTPConfigProvider struct {
+	changeListeners
+	Client      *http.Client
+	base        string
+	namespace   string
+	readOnly    bool
+	fileTypes   []FileType
+	snapshotDir string
+	mtx         sync.Mutex
+	entries     map[string]*httpEntry
+	// checked holds the entries fetched by Has, for the Unmarshal that
+	// follows it in the same load.
+	checked map[string]httpCheck
+}
+
+// httpCheckWindow is the time an entry fetched by Has is reused by Unmarshal.
+const httpCheckWindow = time.Second
+
+type httpCheck struct {
+	entry *httpEntry
+	at    time.Time
+}
+
+type httpEntry struct {
+	Found       bool      `json:"found"`
+	Data        []byte    `json:"data"`
+	ContentType string    `json:"contentType"`
+	ETag        string    `json:"etag"`
+	Expires     time.Time `json:"-"`
+}
+
+func NewHTTPConfigProvider(base, namespace string, readOnly bool) *HTTPConfigProvider {
+	return &HTTPConfigProvider{
+		Client:    http.DefaultClient,
+		base:      strings.TrimSuffix(base, "/"),
+		namespace: namespace,
+		readOnly:  readOnly,
+		entries:   make(map[string]*httpEntry),
+		checked:   make(map[string]httpCheck),
+	}
+}
+
+func (p *HTTPConfigProvider) RegisterFiletype(t FileType) {
+	p.fileTypes = append(p.fileTypes, t)
+}
+
+// SetSnapshotDir sets the directory of the last known good values.
+func (p *HTTPConfigProvider) SetSnapshotDir(dir string) {
+	p.snapshotDir = dir
+}
+
+func (p *HTTPConfigProvider) url(key string) string {
+	return p.base + "/" + url.PathEscape(p.namespace) + "/" + url.PathEscape(key)
+}
+
+func (p *HTTPConfigProvider) Describe(key string) string {
+	return "http:" + p.url(key)
+}
+
+func (p *HTTPConfigProvider) Has(key string) bool {
+	entry, err := p.get(key)
+	if err != nil {
+		return false
+	}
+
+	p.mtx.Lock()
+	p.checked[key] = httpCheck{entry: entry, at: time.Now()}
+	p.mtx.Unlock()
+
+	return entry.Found
+}
+
+func (p *HTTPConfigProvider) Unmarshal(key string, v interface{}) error {
+	p.mtx.Lock()
+	check, checked := p.checked[key]
+	delete(p.checked, key)
+	p.mtx.Unlock()
+
+	entry := check.entry
+	if !checked || time.Since(check.at) > httpCheckWindow {
+		var err error
+		if entry, err = p.get(key); err != nil {
+			return err
+		}
+	}
+	if !entry.Found {
+		return &KeyNotFoundError{Key: key}
+	}
+
+	ft := p.fileTypeFor(entry.ContentType)
+	if ft == nil {
+		return errors.Errorf("no file type for content type %q", entry.ContentType)
+	}
+
+	return ft.Unmarshal(bytes.NewReader(entry.Data), v)
+}
+
+// CacheTTL returns the remaining max-age of a value. Values without a
+// max-age do not expire from the cache of the collection. Values whose
+// max-age has elapsed, and keys that were not loaded, expire immediately.
+func (p *HTTPConfigProvider) CacheTTL(key string) time.Duration {
+	p.mtx.Lock()
+	entry := p.entries[key]
+	p.mtx.Unlock()
+
+	if entry == nil {
+		return expiredCacheTTL
+	}
+	if entry.Expires.IsZero() {
+		return 0
+	}
+
+	if ttl := time.Until(entry.Expires); ttl > 0 {
+		return ttl
+	}
+
+	return expiredCacheTTL
+}
+
+// Poll revalidates the values that were loaded before, and notifies the
+// listeners about the changed ones.
+func (p *HTTPConfigProvider) Poll() error {
+	p.mtx.Lock()
+	keys := make([]string, 0, len(p.entries))
+	for key := range p.entries {
+		keys = append(keys, key)
+	}
+	p.mtx.Unlock()
+
+	var lastErr error
+	for _, key := range keys {
+		if _, err := p.fetch(key, true); err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+func (p *HTTPConfigProvider) get(key string) (*httpEntry, error) {
+	return p.fetch(key, false)
+}
+
+func (p *HTTPConfigProvider) fetch(key string, force bool) (*httpEntry, error) {
+	p.mtx.Lock()
+	cached := p.entries[key]
+	p.mtx.Unlock()
+
+	if cached != nil && !force && time.Now().Before(cached.Expires) {
+		return cached, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.url(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept := p.accept(); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return p.fallback(key, cached, err)
+	}
+	defer util.MustClose(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		entry := *cached
+		entry.Expires = cacheExpiry(resp.Header)
+		p.store(key, &entry, false)
+		return &entry, nil
+	case resp.StatusCode == http.StatusNotFound:
+		entry := &httpEntry{
+			Expires: cacheExpiry(resp.Header),
+		}
+		p.store(key, entry, cached != nil && cached.Found)
+		return entry, nil
+	case resp.StatusCode >= 500:
+		return p.fallback(key, cached, errors.Errorf("unexpected status %s from %s", resp.Status, req.URL))
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.Errorf("unexpected status %s from %s", resp.Status, req.URL)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return p.fallback(key, cached, err)
+	}
+
+	entry := &httpEntry{
+		Found:       true,
+		Data:        data,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+		Expires:     cacheExpiry(resp.Header),
+	}
+	changed := cached == nil || !cached.Found || !bytes.Equal(cached.Data, data)
+	p.store(key, entry, changed && cached != nil)
+	if changed {
+		p.writeSnapshot(key, entry)
+	}
+
+	return entry, nil
+}
+
+// fallback returns the last known good value of a key, from the memory or
+// the snapshot directory.
+func (p *HTTPConfigProvider) fallback(key string, cached *httpEntry, err error) (*httpEntry, error) {
+	if cached != nil {
+		return cached, nil
+	}
+
+	entry, serr := p.readSnapshot(key)
+	if serr != nil || entry == nil {
+		return nil, err
+	}
+
+	p.store(key, entry, false)
+
+	return entry, nil
+}
+
+func (p *HTTPConfigProvider) store(key string, entry *httpEntry, notify bool) {
+	p.mtx.Lock()
+	p.entries[key] = entry
+	p.mtx.Unlock()
+
+	if notify {
+		p.notify(key)
+	}
+}
+
+func (p *HTTPConfigProvider) snapshotFile(key string) string {
+	return filepath.Join(p.snapshotDir, url.PathEscape(key)+".json")
+}
+
+func (p *HTTPConfigProvider) readSnapshot(key string) (*httpEntry, error) {
+	if p.snapshotDir == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(p.snapshotFile(key))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &httpEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// writeSnapshot saves the last known good value of a key. Failing to write
+// the snapshot does not fail the request.
+func (p *HTTPConfigProvider) writeSnapshot(key string, entry *httpEntry) {
+	if p.snapshotDir == "" {
+		return
+	}
+
+	if err := os.MkdirAll(p.snapshotDir, 0700); err != nil {
+		return
+	}
+
+	_ = writeFileAtomic(p.snapshotFile(key), 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(entry)
+	})
+}
+
+func (p *HTTPConfigProvider) accept() string {
+	var types []string
+	for _, t := range p.fileTypes {
+		if mt, ok := t.(MediaTyper); ok {
+			types = append(types, mt.MediaTypes()...)
+		}
+	}
+
+	parts := make([]string, len(types))
+	for i, t := range types {
+		q := 1 - float64(i)/float64(len(types)+1)
+		parts[i] = t
+		if i > 0 {
+			parts[i] += ";q=" + strconv.FormatFloat(q, 'f', 2, 64)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (p *HTTPConfigProvider) fileTypeFor(contentType string) FileType {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		for _, t := range p.fileTypes {
+			if mt, ok := t.(MediaTyper); ok {
+				for _, m := range mt.MediaTypes() {
+					if m == mediaType {
+						return t
+					}
+				}
+			}
+		}
+	}
+
+	if len(p.fileTypes) > 0 {
+		return p.fileTypes[0]
+	}
+
+	return nil
+}
+
+func (p *HTTPConfigProvider) CanSave(_ string) bool {
+	return !p.readOnly
+}
+
+func (p *HTTPConfigProvider) Save(key string, v interface{}) error {
+	return p.put(key, v, nil)
+}
+
+// Revision returns the ETag of a key.
+func (p *HTTPConfigProvider) Revision(key string) (string, error) {
+	entry, err := p.get(key)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.ETag, nil
+}
+
+// SaveRevision saves a value with a conditional PUT request.
+func (p *HTTPConfigProvider) SaveRevision(key string, v interface{}, revision string) error {
+	return p.put(key, v, &revision)
+}
+
+func (p *HTTPConfigProvider) put(key string, v interface{}, revision *string) error {
+	if len(p.fileTypes) == 0 {
+		return errors.New("no configured file type for this http config provider")
+	}
+
+	ft := p.fileTypes[0]
+	buf := bytes.NewBuffer(nil)
+	if err := ft.Marshal(buf, v); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	req, err := http.NewRequest(http.MethodPut, p.url(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	contentType := ""
+	if mt, ok := ft.(MediaTyper); ok {
+		contentType = mt.MediaTypes()[0]
+		req.Header.Set("Content-Type", contentType)
+	}
+	if revision != nil {
+		if *revision == "" {
+			req.Header.Set("If-None-Match", "*")
+		} else {
+			req.Header.Set("If-Match", *revision)
+		}
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer util.MustClose(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		conflict := &ConflictError{
+			Key:    key,
+			Actual: resp.Header.Get("ETag"),
+		}
+		if revision != nil {
+			conflict.Expected = *revision
+		}
+		if conflict.Actual == "" {
+			conflict.Actual, _ = p.fetchRevision(key)
+		}
+		return conflict
+	default:
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, req.URL)
+	}
+
+	entry := &httpEntry{
+		Found:       true,
+		Data:        data,
+		ContentType: contentType,
+		ETag:        resp.Header.Get("ETag"),
+	}
+	p.writeSnapshot(key, entry)
+
+	// without an ETag, the next read fetches the new revision
+	p.mtx.Lock()
+	if entry.ETag != "" {
+		p.entries[key] = entry
+	} else {
+		delete(p.entries, key)
+	}
+	p.mtx.Unlock()
+
+	p.notify(key)
+
+	return nil
+}
+
+func (p *HTTPConfigProvider) fetchRevision(key string) (string, error) {
+	entry, err := p.fetch(key, true)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.ETag, nil
+}
+
+// cacheExpiry returns the expiry of a response according to the max-age of
+// its Cache-Control header. Responses without max-age have no expiry, and are
+// revalidated on every use.
+func cacheExpiry(header http.Header) time.Time {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if strings.HasPrefix(directive, "max-age=") {
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil {
+				return time.Now().Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+	"github.com/tamasd/constellation/util"
+)
+
+type configServer struct {
+	mtx          sync.Mutex
+	values       map[string]string
+	revision     map[string]int
+	requests     int
+	cacheControl string
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.requests++
+
+	value, found := s.values[r.URL.Path]
+	etag := `"` + strconv.Itoa(s.revision[r.URL.Path]) + `"`
+
+	switch r.Method {
+	case http.MethodGet:
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if s.cacheControl == "" {
+			w.Header().Set("Cache-Control", "max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(value))
+	case http.MethodPut:
+		if match := r.Header.Get("If-Match"); match != "" && (!found || match != etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		s.values[r.URL.Path] = string(data)
+		s.revision[r.URL.Path]++
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestHTTPConfigProvider(t *testing.T) {
+	snapshots, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(snapshots)) }()
+
+	cs := &configServer{
+		values:   map[string]string{"/ns/test": util.JSONString(testExample())},
+		revision: map[string]int{"/ns/test": 1},
+	}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+
+	newStore := func() *config.Store {
+		loader := config.NewHTTP(srv.URL, false)
+		loader.SetSnapshotDir(snapshots)
+		c := config.NewStore(null.NewLogger())
+		c.RegisterSchema("test", reflect.TypeOf(test{}))
+		c.RegisterSchema("missing", reflect.TypeOf(test{}))
+		c.AddCollectionLoaders(loader)
+		return c
+	}
+	c := newStore()
+
+	t.Run("values are loaded and cached", func(t *testing.T) {
+		v, err := c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, testExample(), v)
+
+		c.Invalidate("ns", "test")
+		requests := cs.requests
+		_, err = c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, requests, cs.requests)
+
+		v, err = c.Get("ns").Get("missing")
+		require.NoError(t, err)
+		require.Nil(t, v)
+	})
+
+	t.Run("stale saves conflict", func(t *testing.T) {
+		v, saver, err := c.GetWritable("ns").GetWritable("test")
+		require.NoError(t, err)
+		require.Equal(t, `"1"`, saver.Revision())
+
+		cs.mtx.Lock()
+		cs.revision["/ns/test"]++
+		cs.mtx.Unlock()
+
+		err = saver.Save(v)
+		require.IsType(t, &config.ConflictError{}, err)
+
+		_, saver, err = c.GetWritable("ns").GetWritable("test")
+		require.NoError(t, err)
+		changed := testExample()
+		changed.B = "qwer"
+		require.NoError(t, saver.Save(changed))
+
+		v, err = c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, changed, v)
+	})
+
+	t.Run("snapshot is used when the server is down", func(t *testing.T) {
+		srv.Close()
+
+		v, err := newStore().Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, "qwer", v.(test).B)
+	})
+}
+
+func TestHTTPConfigProviderCaching(t *testing.T) {
+	cs := &configServer{
+		values:   map[string]string{"/ns/test": util.JSONString(testExample())},
+		revision: map[string]int{"/ns/test": 1},
+	}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+
+	newStore := func(cacheControl string) *config.Store {
+		cs.mtx.Lock()
+		cs.cacheControl = cacheControl
+		cs.requests = 0
+		cs.mtx.Unlock()
+
+		c := config.NewStore(null.NewLogger())
+		c.RegisterSchema("test", reflect.TypeOf(test{}))
+		c.AddCollectionLoaders(config.NewHTTP(srv.URL, false))
+		return c
+	}
+	requests := func() int {
+		cs.mtx.Lock()
+		defer cs.mtx.Unlock()
+		return cs.requests
+	}
+
+	t.Run("a load sends one request", func(t *testing.T) {
+		c := newStore("no-cache")
+		_, err := c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 1, requests())
+
+		_, err = c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 1, requests())
+	})
+
+	t.Run("values expire after max-age", func(t *testing.T) {
+		c := newStore("max-age=1")
+		_, err := c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 1, requests())
+
+		_, err = c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 1, requests())
+
+		time.Sleep(1100 * time.Millisecond)
+		_, err = c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 2, requests())
+	})
+
+	t.Run("stale values are not cached", func(t *testing.T) {
+		c := newStore("max-age=0")
+		_, err := c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 1, requests())
+
+		_, err = c.Get("ns").Get("test")
+		require.NoError(t, err)
+		require.Equal(t, 2, requests())
+	})
+}
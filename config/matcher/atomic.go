@@ -0,0 +1,109 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package matcher
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AtomicMatcher is a Matcher that is safe for concurrent use.
+//
+// The readers use an immutable snapshot of the patterns without locking. The
+// writers copy the snapshot, modify the copy and replace the snapshot, so
+// writing is slow and it is intended for patterns that rarely change.
+type AtomicMatcher struct {
+	mtx      sync.Mutex
+	snapshot atomic.Value
+}
+
+func NewAtomicMatcher(separator string) *AtomicMatcher {
+	m := &AtomicMatcher{}
+	m.snapshot.Store(NewMatcher(separator))
+
+	return m
+}
+
+func (m *AtomicMatcher) load() *Matcher {
+	return m.snapshot.Load().(*Matcher)
+}
+
+func (m *AtomicMatcher) Get(path string) interface{} {
+	return m.load().Get(path)
+}
+
+func (m *AtomicMatcher) Match(path string) (Match, bool) {
+	return m.load().Match(path)
+}
+
+func (m *AtomicMatcher) Walk(fn func(pattern string, content interface{})) {
+	m.load().Walk(fn)
+}
+
+func (m *AtomicMatcher) Set(pattern string, content interface{}) {
+	m.Update(func(mm *Matcher) {
+		mm.Set(pattern, content)
+	})
+}
+
+func (m *AtomicMatcher) Delete(pattern string) bool {
+	deleted := false
+	m.Update(func(mm *Matcher) {
+		deleted = mm.Delete(pattern)
+	})
+
+	return deleted
+}
+
+// Update modifies a copy of the current snapshot, and replaces the snapshot
+// with it. The readers see either none or all of the changes.
+func (m *AtomicMatcher) Update(fn func(m *Matcher)) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	current := m.load()
+	next := &Matcher{
+		separator: current.separator,
+		tree:      current.tree.clone(),
+	}
+	fn(next)
+	m.snapshot.Store(next)
+}
+
+// clone copies the tree of an item. The contents are not copied.
+func (i *item) clone() *item {
+	if i == nil {
+		return nil
+	}
+
+	c := &item{
+		children: make(map[string]*item, len(i.children)),
+		wildcard: i.wildcard.clone(),
+		globstar: i.globstar.clone(),
+		pattern:  i.pattern,
+		content:  i.content,
+		set:      i.set,
+	}
+	for name, child := range i.children {
+		c.children[name] = child.clone()
+	}
+
+	return c
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package matcher_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config/matcher"
+)
+
+func TestAtomicMatcher(t *testing.T) {
+	m := matcher.NewAtomicMatcher(".")
+	m.Set("item.*", "wildcard")
+	m.Set("item.{id}.db", "db")
+
+	type lookup struct {
+		value interface{}
+		found bool
+		id    string
+	}
+	lookups := make([][]lookup, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Set("other."+strconv.Itoa(i)+"."+strconv.Itoa(j), j)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				match, found := m.Match("item.foo.db")
+				lookups[i] = append(lookups[i], lookup{m.Get("item.foo"), found, match.Captures["id"]})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, l := range lookups {
+		require.Len(t, l, 100)
+		for _, item := range l {
+			require.Equal(t, lookup{"wildcard", true, "foo"}, item)
+		}
+	}
+
+	require.Equal(t, 99, m.Get("other.3.99"))
+	require.True(t, m.Delete("other.3.99"))
+	require.Nil(t, m.Get("other.3.99"))
+
+	count := 0
+	m.Walk(func(pattern string, content interface{}) {
+		count++
+	})
+	require.Equal(t, 401, count)
+}
+
+func benchmarkPatterns() []string {
+	patterns := []string{"**.db", "tenant.{id}.cache"}
+	for i := 0; i < 100; i++ {
+		patterns = append(patterns, "service"+strconv.Itoa(i)+".*")
+	}
+
+	return patterns
+}
+
+func BenchmarkMatcher_RWMutex(b *testing.B) {
+	m := matcher.NewMatcher(".")
+	for _, pattern := range benchmarkPatterns() {
+		m.Set(pattern, pattern)
+	}
+	var mtx sync.RWMutex
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mtx.RLock()
+			m.Get("service50.pool")
+			mtx.RUnlock()
+		}
+	})
+}
+
+func BenchmarkMatcher_Atomic(b *testing.B) {
+	m := matcher.NewAtomicMatcher(".")
+	for _, pattern := range benchmarkPatterns() {
+		m.Set(pattern, pattern)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Get("service50.pool")
+		}
+	})
+}
@@ -20,14 +20,35 @@
 package matcher
 
 import (
+	"sort"
 	"strings"
 )
 
+// Matcher stores contents by patterns, and finds the content of the pattern
+// that matches a path.
+//
+// The patterns are split into segments with the separator. A segment is
+// either a literal, a * that matches any single segment, a {name} that
+// matches any single segment and captures it as name, or a ** that matches
+// zero or more segments. The * and {name} segments are interchangeable: a
+// pattern replaces the other one if they only differ in these.
+//
+// When more patterns match a path, the segments are compared from left to
+// right, and a literal takes precedence over a single segment wildcard,
+// which takes precedence over a **. A ** matches as few segments as
+// possible.
 type Matcher struct {
 	separator string
 	tree      *item
 }
 
+// Match is a pattern that matches a path.
+type Match struct {
+	Pattern  string
+	Content  interface{}
+	Captures map[string]string
+}
+
 func NewMatcher(separator string) *Matcher {
 	return &Matcher{
 		separator: separator,
@@ -35,29 +56,93 @@ func NewMatcher(separator string) *Matcher {
 	}
 }
 
-func (m *Matcher) get(path string, create bool) *item {
+// Get returns the content of the pattern that matches a path, or nil.
+func (m *Matcher) Get(path string) interface{} {
 	parts := strings.Split(path, m.separator)
-	return m.tree.get(parts, create)
+	matched, _ := m.tree.match(parts, make([]string, 0, len(parts)), m.separator)
+	if matched == nil {
+		return nil
+	}
+
+	return matched.content
 }
 
-func (m *Matcher) Get(path string) interface{} {
-	item := m.get(path, false)
-	if item == nil {
-		return nil
+// Match returns the pattern that matches a path, with its content and the
+// values of its captures.
+func (m *Matcher) Match(path string) (Match, bool) {
+	parts := strings.Split(path, m.separator)
+	matched, segments := m.tree.match(parts, make([]string, 0, len(parts)), m.separator)
+	if matched == nil {
+		return Match{}, false
 	}
 
-	return item.content
+	match := Match{
+		Pattern: matched.pattern,
+		Content: matched.content,
+	}
+	for i, part := range strings.Split(matched.pattern, m.separator) {
+		if name, ok := captureName(part); ok {
+			if match.Captures == nil {
+				match.Captures = make(map[string]string)
+			}
+			match.Captures[name] = segments[i]
+		}
+	}
+
+	return match, true
 }
 
-func (m *Matcher) Set(path string, content interface{}) {
-	item := m.get(path, true)
+func (m *Matcher) Set(pattern string, content interface{}) {
+	item := m.tree
+	for _, part := range strings.Split(pattern, m.separator) {
+		item = item.child(part, true)
+	}
+
+	item.pattern = pattern
 	item.content = content
+	item.set = true
+}
+
+// Delete removes a pattern. Returns false if the pattern is not found.
+func (m *Matcher) Delete(pattern string) bool {
+	parts := strings.Split(pattern, m.separator)
+	items := []*item{m.tree}
+	for _, part := range parts {
+		child := items[len(items)-1].child(part, false)
+		if child == nil {
+			return false
+		}
+		items = append(items, child)
+	}
+
+	last := items[len(items)-1]
+	if !last.set {
+		return false
+	}
+	last.pattern = ""
+	last.content = nil
+	last.set = false
+
+	for i := len(parts) - 1; i >= 0 && items[i+1].empty(); i-- {
+		items[i].remove(parts[i])
+	}
+
+	return true
+}
+
+// Walk calls fn with every pattern and its content. The literal segments
+// are visited in alphabetical order, followed by the wildcards.
+func (m *Matcher) Walk(fn func(pattern string, content interface{})) {
+	m.tree.walk(fn)
 }
 
 type item struct {
 	children map[string]*item
 	wildcard *item
+	globstar *item
+	pattern  string
 	content  interface{}
+	set      bool
 }
 
 func newItem() *item {
@@ -67,34 +152,111 @@ func newItem() *item {
 	}
 }
 
-func (i *item) get(path []string, create bool) *item {
-	if len(path) == 0 {
-		return i
+// captureName returns the name of a {name} segment.
+func captureName(part string) (string, bool) {
+	if len(part) < 2 || part[0] != '{' || part[len(part)-1] != '}' {
+		return "", false
 	}
 
-	current := path[0]
-	if current == "*" {
-		if i.wildcard != nil {
-			return i.wildcard.get(path[1:], create)
+	return part[1 : len(part)-1], true
+}
+
+func isWildcard(part string) bool {
+	_, capture := captureName(part)
+	return part == "*" || capture
+}
+
+// child returns the item of a pattern segment.
+func (i *item) child(part string, create bool) *item {
+	switch {
+	case part == "**":
+		if i.globstar == nil && create {
+			i.globstar = newItem()
 		}
-		if create {
+		return i.globstar
+	case isWildcard(part):
+		if i.wildcard == nil && create {
 			i.wildcard = newItem()
-			return i.wildcard.get(path[1:], create)
 		}
+		return i.wildcard
 	}
 
-	if childItem, found := i.children[current]; found {
-		return childItem.get(path[1:], create)
+	childItem, found := i.children[part]
+	if !found && create {
+		childItem = newItem()
+		i.children[part] = childItem
 	}
 
-	if create {
-		i.children[current] = newItem()
-		return i.children[current].get(path[1:], create)
+	return childItem
+}
+
+func (i *item) remove(part string) {
+	switch {
+	case part == "**":
+		i.globstar = nil
+	case isWildcard(part):
+		i.wildcard = nil
+	default:
+		delete(i.children, part)
 	}
+}
 
-	if i.wildcard != nil {
-		return i.wildcard.get(path[1:], create)
+func (i *item) empty() bool {
+	return !i.set && len(i.children) == 0 && i.wildcard == nil && i.globstar == nil
+}
+
+// match finds the item of the pattern that matches a path. The segments are
+// the parts of the path matched by the segments of the pattern.
+//
+// If a subtree does not match, the next candidate is tried.
+func (i *item) match(path, segments []string, separator string) (*item, []string) {
+	if len(path) == 0 && i.set {
+		return i, segments
+	}
+
+	if len(path) > 0 {
+		if childItem, found := i.children[path[0]]; found {
+			if matched, s := childItem.match(path[1:], append(segments, path[0]), separator); matched != nil {
+				return matched, s
+			}
+		}
+
+		if i.wildcard != nil {
+			if matched, s := i.wildcard.match(path[1:], append(segments, path[0]), separator); matched != nil {
+				return matched, s
+			}
+		}
+	}
+
+	if i.globstar != nil {
+		for n := 0; n <= len(path); n++ {
+			if matched, s := i.globstar.match(path[n:], append(segments, strings.Join(path[:n], separator)), separator); matched != nil {
+				return matched, s
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (i *item) walk(fn func(pattern string, content interface{})) {
+	if i.set {
+		fn(i.pattern, i.content)
+	}
+
+	names := make([]string, 0, len(i.children))
+	for name := range i.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		i.children[name].walk(fn)
 	}
 
-	return nil
+	if i.wildcard != nil {
+		i.wildcard.walk(fn)
+	}
+	if i.globstar != nil {
+		i.globstar.walk(fn)
+	}
 }
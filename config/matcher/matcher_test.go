@@ -42,3 +42,100 @@ func TestMatcher(t *testing.T) {
 	m.Set("item.*.*.baz", value)
 	require.Equal(t, value, m.Get("item.foo.baz.baz"))
 }
+
+func TestMatcherBacktracking(t *testing.T) {
+	m := matcher.NewMatcher(".")
+	m.Set("item.foo.bar", "literal")
+	m.Set("item.*.baz", "wildcard")
+	m.Set("item.**", "globstar")
+
+	require.Equal(t, "literal", m.Get("item.foo.bar"))
+	require.Equal(t, "wildcard", m.Get("item.foo.baz"))
+	require.Equal(t, "globstar", m.Get("item.foo.qux"))
+	require.Equal(t, "globstar", m.Get("item.foo"))
+	require.Equal(t, "globstar", m.Get("item"))
+	require.Nil(t, m.Get("other.foo"))
+
+	m.Set("item.foo", "partial")
+	require.Equal(t, "partial", m.Get("item.foo"))
+	require.Equal(t, "globstar", m.Get("item.foo.bar.baz"))
+}
+
+func TestMatcherGlobstar(t *testing.T) {
+	m := matcher.NewMatcher(".")
+	m.Set("**.db", "db")
+	m.Set("a.**.b.**.c", "nested")
+
+	require.Equal(t, "db", m.Get("db"))
+	require.Equal(t, "db", m.Get("tenant.x.db"))
+	require.Nil(t, m.Get("tenant.x.db.y"))
+	require.Equal(t, "nested", m.Get("a.b.c"))
+	require.Equal(t, "nested", m.Get("a.x.y.b.z.c"))
+	require.Nil(t, m.Get("a.x.c"))
+}
+
+func TestMatcherCaptures(t *testing.T) {
+	m := matcher.NewMatcher(".")
+	m.Set("tenant.{id}.db", "db")
+	m.Set("tenant.{id}.{name}", "other")
+	m.Set("tenant.admin.db", "admin")
+	m.Set("region.**.{zone}", "zone")
+
+	match, found := m.Match("tenant.acme.db")
+	require.True(t, found)
+	require.Equal(t, matcher.Match{
+		Pattern:  "tenant.{id}.db",
+		Content:  "db",
+		Captures: map[string]string{"id": "acme"},
+	}, match)
+
+	match, found = m.Match("tenant.acme.cache")
+	require.True(t, found)
+	require.Equal(t, "tenant.{id}.{name}", match.Pattern)
+	require.Equal(t, map[string]string{"id": "acme", "name": "cache"}, match.Captures)
+
+	match, found = m.Match("tenant.admin.db")
+	require.True(t, found)
+	require.Equal(t, "admin", match.Content)
+	require.Nil(t, match.Captures)
+
+	match, found = m.Match("region.eu.west.a")
+	require.True(t, found)
+	require.Equal(t, map[string]string{"zone": "a"}, match.Captures)
+
+	_, found = m.Match("tenant.acme")
+	require.False(t, found)
+}
+
+func TestMatcherDeleteWalk(t *testing.T) {
+	m := matcher.NewMatcher(".")
+	m.Set("b.*", 1)
+	m.Set("a.x", 2)
+	m.Set("a.**", 3)
+	m.Set("a", 4)
+	m.Set("b.{id}.c", 5)
+
+	var patterns []string
+	m.Walk(func(pattern string, content interface{}) {
+		patterns = append(patterns, pattern)
+	})
+	require.Equal(t, []string{"a", "a.x", "a.**", "b.*", "b.{id}.c"}, patterns)
+
+	require.True(t, m.Delete("a.x"))
+	require.False(t, m.Delete("a.x"))
+	require.False(t, m.Delete("a.y"))
+	require.Equal(t, 3, m.Get("a.x"))
+
+	require.True(t, m.Delete("b.*"))
+	require.Nil(t, m.Get("b.foo"))
+	require.Equal(t, 5, m.Get("b.foo.c"))
+
+	require.True(t, m.Delete("b.{id}.c"))
+	require.Nil(t, m.Get("b.foo.c"))
+
+	patterns = nil
+	m.Walk(func(pattern string, content interface{}) {
+		patterns = append(patterns, pattern)
+	})
+	require.Equal(t, []string{"a", "a.**"}, patterns)
+}
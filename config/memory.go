@@ -20,13 +20,20 @@
 package config
 
 import (
+	"sync"
+
 	"github.com/imdario/mergo"
 	"github.com/pkg/errors"
 )
 
 var _ WritableProvider = &MemoryConfigProvider{}
+var _ ChangeNotifier = &MemoryConfigProvider{}
+var _ Describer = &MemoryConfigProvider{}
+var _ KeyLister = &MemoryConfigProvider{}
 
 type MemoryConfigProvider struct {
+	changeListeners
+	mtx   sync.RWMutex
 	store map[string]interface{}
 }
 
@@ -37,7 +44,9 @@ func NewMemoryConfigProvider() *MemoryConfigProvider {
 }
 
 func (m *MemoryConfigProvider) Reset() {
+	m.mtx.Lock()
 	m.store = make(map[string]interface{})
+	m.mtx.Unlock()
 }
 
 func (m *MemoryConfigProvider) CanSave(key string) bool {
@@ -45,17 +54,42 @@ func (m *MemoryConfigProvider) CanSave(key string) bool {
 }
 
 func (m *MemoryConfigProvider) Save(key string, v interface{}) error {
+	m.mtx.Lock()
 	m.store[key] = v
+	m.mtx.Unlock()
+
+	m.notify(key)
+
 	return nil
 }
 
 func (m *MemoryConfigProvider) Has(key string) bool {
+	m.mtx.RLock()
 	_, found := m.store[key]
+	m.mtx.RUnlock()
 	return found
 }
 
+func (m *MemoryConfigProvider) Keys() ([]string, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	keys := make([]string, 0, len(m.store))
+	for key := range m.store {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m *MemoryConfigProvider) Describe(key string) string {
+	return "memory:" + key
+}
+
 func (m *MemoryConfigProvider) Unmarshal(key string, v interface{}) error {
+	m.mtx.RLock()
 	val, found := m.store[key]
+	m.mtx.RUnlock()
 	if found {
 		return mergo.Merge(v, val)
 	}
@@ -0,0 +1,147 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/tamasd/constellation/config/validate"
+)
+
+// deepCopy copies a value, including the contents of its pointers, slices
+// and maps.
+func deepCopy(rv reflect.Value) reflect.Value {
+	cp := reflect.New(rv.Type()).Elem()
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return cp
+		}
+		ptr := reflect.New(rv.Type().Elem())
+		ptr.Elem().Set(deepCopy(rv.Elem()))
+		cp.Set(ptr)
+	case reflect.Interface:
+		if rv.IsNil() {
+			return cp
+		}
+		cp.Set(deepCopy(rv.Elem()))
+	case reflect.Struct:
+		cp.Set(rv)
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				cp.Field(i).Set(deepCopy(rv.Field(i)))
+			}
+		}
+	case reflect.Slice:
+		if rv.IsNil() {
+			return cp
+		}
+		slice := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			slice.Index(i).Set(deepCopy(rv.Index(i)))
+		}
+		cp.Set(slice)
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			cp.Index(i).Set(deepCopy(rv.Index(i)))
+		}
+	case reflect.Map:
+		if rv.IsNil() {
+			return cp
+		}
+		m := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		cp.Set(m)
+	default:
+		cp.Set(rv)
+	}
+
+	return cp
+}
+
+// rewriteStrings replaces every string in a settable value with the result
+// of f.
+//
+// The paths passed to f use the same format as the validate package.
+func rewriteStrings(path string, rv reflect.Value, f func(path, s string) (string, error)) error {
+	switch rv.Kind() {
+	case reflect.String:
+		if !rv.CanSet() {
+			return nil
+		}
+		s, err := f(path, rv.String())
+		if err != nil {
+			return err
+		}
+		if s != rv.String() {
+			rv.SetString(s)
+		}
+	case reflect.Ptr:
+		if !rv.IsNil() {
+			return rewriteStrings(path, rv.Elem(), f)
+		}
+	case reflect.Interface:
+		if rv.IsNil() || !rv.CanSet() {
+			return nil
+		}
+		cp := reflect.New(rv.Elem().Type()).Elem()
+		cp.Set(rv.Elem())
+		if err := rewriteStrings(path, cp, f); err != nil {
+			return err
+		}
+		rv.Set(cp)
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if err := rewriteStrings(validate.JoinPath(path, field.Name), rv.Field(i), f); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := rewriteStrings(path+"["+strconv.Itoa(i)+"]", rv.Index(i), f); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			cp := reflect.New(iter.Value().Type()).Elem()
+			cp.Set(iter.Value())
+			if err := rewriteStrings(path+"["+fmt.Sprint(iter.Key().Interface())+"]", cp, f); err != nil {
+				return err
+			}
+			rv.SetMapIndex(iter.Key(), cp)
+		}
+	}
+
+	return nil
+}
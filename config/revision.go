@@ -0,0 +1,142 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+// RevisionedProvider is implemented by writable providers that can detect
+// concurrent modifications of their values.
+type RevisionedProvider interface {
+	WritableProvider
+	// Revision returns the current revision of a key, or an empty string if
+	// the key does not exist.
+	Revision(key string) (string, error)
+	// SaveRevision saves a value if the current revision of the key is the
+	// given one, and returns a ConflictError otherwise.
+	SaveRevision(key string, v interface{}, revision string) error
+}
+
+var _ error = &ConflictError{}
+
+// ConflictError is returned when a value was modified since it was read.
+type ConflictError struct {
+	Namespace string
+	Key       string
+	Expected  string
+	Actual    string
+}
+
+func (e *ConflictError) Error() string {
+	name := e.Key
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + e.Key
+	}
+
+	return "config " + name + " was modified concurrently: expected revision " + quoteRevision(e.Expected) + ", found " + quoteRevision(e.Actual)
+}
+
+func quoteRevision(revision string) string {
+	if revision == "" {
+		return "<none>"
+	}
+
+	return revision
+}
+
+// getWritable loads a value with its revision and secret references,
+// bypassing the cache.
+func (s *Store) getWritable(namespace, key string) (interface{}, string, map[string]secretRef, error) {
+	collection := s.ensureNamespace(namespace)
+	if collection == nil {
+		return nil, "", nil, CollectionNotFoundError{namespace}
+	}
+
+	sc := s.schemas.Get(key)
+	if sc == nil {
+		return nil, "", nil, withNamespace(errors.New("schema not found"), namespace)
+	}
+
+	val, revision, refs, err := collection.getWritable(key, sc.(*schema))
+
+	return val, revision, refs, withNamespace(err, namespace)
+}
+
+// maxRevisionReads is the number of attempts to read a value that is not
+// modified while it is loaded.
+const maxRevisionReads = 3
+
+// getWritable loads a value from the providers together with its revision.
+//
+// A cached value might be older than the current revision, so the providers
+// are read directly, and the revision is checked before and after the load.
+func (c *Collection) getWritable(key string, sc *schema) (interface{}, string, map[string]secretRef, error) {
+	for attempt := 0; ; attempt++ {
+		generation := c.cacheGeneration()
+
+		revision, err := c.revision(key)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		val, refs, err := c.find(key, sc)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		current, err := c.revision(key)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if current == revision {
+			c.putLoadedToCache(key, val, generation)
+			return val, revision, refs, nil
+		}
+
+		if attempt+1 == maxRevisionReads {
+			return nil, "", nil, &ConflictError{
+				Key:      key,
+				Expected: revision,
+				Actual:   current,
+			}
+		}
+	}
+}
+
+func (c *Collection) revision(key string) (string, error) {
+	if rp, ok := c.writableProvider(key).(RevisionedProvider); ok {
+		return rp.Revision(key)
+	}
+
+	return "", nil
+}
+
+// writableProvider returns the provider that saves the values of a key.
+func (c *Collection) writableProvider(key string) WritableProvider {
+	for _, provider := range c.getProviders() {
+		if wp, ok := provider.(WritableProvider); ok && wp.CanSave(key) {
+			return wp
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Redacted replaces the values of secrets in the outputs meant for humans.
+const Redacted = "[redacted]"
+
+var secretReference = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]*)\}`)
+
+// SecretResolver resolves a secret reference.
+//
+// A string value like "${file:/run/secrets/db_password}" is resolved by
+// the resolver registered with the "file" scheme, and ref is
+// "/run/secrets/db_password".
+type SecretResolver interface {
+	ResolveSecret(ref string) (string, error)
+}
+
+var _ SecretResolver = SecretResolverFunc(nil)
+
+type SecretResolverFunc func(ref string) (string, error)
+
+func (f SecretResolverFunc) ResolveSecret(ref string) (string, error) {
+	return f(ref)
+}
+
+var _ SecretResolver = FileSecretResolver{}
+
+// FileSecretResolver reads secrets from files, without the trailing newline.
+type FileSecretResolver struct{}
+
+func (FileSecretResolver) ResolveSecret(ref string) (string, error) {
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var _ SecretResolver = EnvSecretResolver{}
+
+// EnvSecretResolver reads secrets from environment variables.
+type EnvSecretResolver struct{}
+
+func (EnvSecretResolver) ResolveSecret(ref string) (string, error) {
+	val, found := os.LookupEnv(ref)
+	if !found {
+		return "", errors.New("environment variable not found: " + ref)
+	}
+
+	return val, nil
+}
+
+type secretResolvers struct {
+	mtx       sync.RWMutex
+	resolvers map[string]SecretResolver
+}
+
+func newSecretResolvers() *secretResolvers {
+	return &secretResolvers{
+		resolvers: map[string]SecretResolver{
+			"file": FileSecretResolver{},
+			"env":  EnvSecretResolver{},
+		},
+	}
+}
+
+func (r *secretResolvers) get(scheme string) SecretResolver {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	return r.resolvers[scheme]
+}
+
+type secretRef struct {
+	template string
+	resolved string
+}
+
+// AddSecretResolver registers a secret resolver for a scheme.
+//
+// The "file" and "env" schemes are registered by default.
+func (s *Store) AddSecretResolver(scheme string, r SecretResolver) {
+	s.secrets.mtx.Lock()
+	s.secrets.resolvers[scheme] = r
+	s.secrets.mtx.Unlock()
+}
+
+func (c *Collection) setSecretResolvers(r *secretResolvers) {
+	c.mtx.Lock()
+	c.secrets = r
+	c.mtx.Unlock()
+}
+
+// resolveSecrets replaces the secret references in a value in place.
+//
+// Returns the references by the path of the fields.
+func (c *Collection) resolveSecrets(ptr reflect.Value) (map[string]secretRef, error) {
+	c.mtx.RLock()
+	resolvers := c.secrets
+	c.mtx.RUnlock()
+
+	if resolvers == nil {
+		return nil, nil
+	}
+
+	// The merged value can share slices and maps with the providers.
+	ptr.Elem().Set(deepCopy(ptr.Elem()))
+
+	refs := make(map[string]secretRef)
+	err := rewriteStrings("", ptr.Elem(), func(path, s string) (string, error) {
+		if !strings.Contains(s, "${") {
+			return s, nil
+		}
+
+		var resolveErr error
+		resolved := secretReference.ReplaceAllStringFunc(s, func(ref string) string {
+			parts := secretReference.FindStringSubmatch(ref)
+			resolver := resolvers.get(parts[1])
+			if resolver == nil {
+				return ref
+			}
+			secret, err := resolver.ResolveSecret(parts[2])
+			if err != nil && resolveErr == nil {
+				resolveErr = errors.Wrap(err, path)
+			}
+			return secret
+		})
+		if resolveErr != nil {
+			return "", resolveErr
+		}
+
+		if resolved != s {
+			refs[path] = secretRef{
+				template: s,
+				resolved: resolved,
+			}
+		}
+
+		return resolved, nil
+	})
+
+	return refs, err
+}
+
+// containsSecret checks if a path or any of its children is a secret.
+func containsSecret(refs map[string]secretRef, path string) bool {
+	if _, found := refs[path]; found {
+		return true
+	}
+
+	for p := range refs {
+		if path == "" || strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restoreSecretRefs replaces the unchanged resolved secrets with their
+// references, so they are not persisted.
+func restoreSecretRefs(refs map[string]secretRef, v interface{}) interface{} {
+	if len(refs) == 0 || v == nil {
+		return v
+	}
+
+	ptr := reflect.New(reflect.TypeOf(v))
+	ptr.Elem().Set(deepCopy(reflect.ValueOf(v)))
+	_ = rewriteStrings("", ptr.Elem(), func(path, s string) (string, error) {
+		if ref, found := refs[path]; found && ref.resolved == s {
+			return ref.template, nil
+		}
+		return s, nil
+	})
+
+	return ptr.Elem().Interface()
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config/matcher"
+	"github.com/tamasd/constellation/logger/null"
+)
+
+type benchmarkValue struct {
+	A int
+	B string
+}
+
+// BenchmarkStoreGet compares the lock-free schema lookup of Store.get with
+// looking up the schema under the read lock of the store.
+func BenchmarkStoreGet(b *testing.B) {
+	s := NewStore(null.NewLogger())
+	s.RegisterSchema("test.*", reflect.TypeOf(benchmarkValue{}))
+	for i := 0; i < 100; i++ {
+		s.RegisterSchema("service"+strconv.Itoa(i)+".*", reflect.TypeOf(benchmarkValue{}))
+	}
+
+	mp := NewMemoryConfigProvider()
+	require.NoError(b, mp.Save("test.0", benchmarkValue{A: 5, B: "asdf"}))
+	collection := NewCollection()
+	collection.AddProviders(mp)
+	s.AddCollection("config", collection)
+
+	b.Run("RWMutex", func(b *testing.B) {
+		schemas := matcher.NewMatcher(".")
+		s.schemas.Walk(func(pattern string, content interface{}) {
+			schemas.Set(pattern, content)
+		})
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				collection := s.ensureNamespace("config")
+				s.mtx.RLock()
+				_, err := collection.get("test.0", schemas.Get("test.0").(*schema))
+				s.mtx.RUnlock()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+
+	b.Run("Atomic", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := s.get("config", "test.0"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
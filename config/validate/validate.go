@@ -0,0 +1,311 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Validator is implemented by types that can validate themselves.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError describes a single failed rule.
+type FieldError struct {
+	// Path is the path of the field, e.g. "Pool.Hosts[0].Port". Empty for
+	// the validated value itself.
+	Path string
+	Rule string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Errors is a list of failed rules.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the rules in the `validate` struct tags, and calls the
+// Validate() methods of the value and its nested structs.
+//
+// Supported rules: required, min=N, max=N, len=N, oneof=a b c. For strings,
+// slices and maps min, max and len apply to the length.
+//
+// The returned error is either nil or Errors.
+func Validate(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		rv = ptr
+	}
+
+	var errs Errors
+	walk("", rv, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func walk(path string, rv reflect.Value, errs *Errors) {
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return
+		}
+		callValidator(path, rv, errs)
+		rv = rv.Elem()
+	} else if rv.CanAddr() {
+		callValidator(path, rv.Addr(), errs)
+	} else {
+		callValidator(path, rv, errs)
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		walk(path, rv, errs)
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			tag := field.Tag.Get("validate")
+			if tag == "-" {
+				continue
+			}
+			fieldPath := JoinPath(path, field.Name)
+			fv := rv.Field(i)
+			if tag != "" {
+				checkRules(fieldPath, tag, fv, errs)
+			}
+			walk(fieldPath, fv, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			walk(path+"["+strconv.Itoa(i)+"]", rv.Index(i), errs)
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			walk(path+"["+fmt.Sprint(iter.Key().Interface())+"]", iter.Value(), errs)
+		}
+	}
+}
+
+func callValidator(path string, rv reflect.Value, errs *Errors) {
+	if !rv.IsValid() || !rv.CanInterface() {
+		return
+	}
+
+	if v, ok := rv.Interface().(Validator); ok {
+		if err := v.Validate(); err != nil {
+			*errs = append(*errs, &FieldError{
+				Path: path,
+				Rule: "Validate",
+				Err:  err,
+			})
+		}
+	}
+}
+
+func checkRules(path, tag string, rv reflect.Value, errs *Errors) {
+	for _, rule := range strings.Split(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		name, param := rule, ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+
+		if err := checkRule(name, param, rv); err != nil {
+			*errs = append(*errs, &FieldError{
+				Path: path,
+				Rule: name,
+				Err:  err,
+			})
+		}
+	}
+}
+
+func checkRule(name, param string, rv reflect.Value) error {
+	if name == "required" {
+		if rv.IsZero() {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	}
+
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch name {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s parameter: %q", name, param)
+		}
+		value, isLength, ok := measure(rv)
+		if !ok {
+			return fmt.Errorf("rule %s is not supported for %s", name, rv.Type())
+		}
+		subject := "must be"
+		if isLength {
+			subject = "length must be"
+		}
+		switch {
+		case name == "min" && value < limit:
+			return fmt.Errorf("%s at least %s", subject, param)
+		case name == "max" && value > limit:
+			return fmt.Errorf("%s at most %s", subject, param)
+		case name == "len" && value != limit:
+			return fmt.Errorf("%s exactly %s", subject, param)
+		}
+	case "oneof":
+		options := strings.Fields(param)
+		current := fmt.Sprint(rv.Interface())
+		for _, option := range options {
+			if option == current {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of: %s", strings.Join(options, ", "))
+	default:
+		return fmt.Errorf("unknown rule: %s", name)
+	}
+
+	return nil
+}
+
+func measure(rv reflect.Value) (float64, bool, bool) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), false, true
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(rv.Len()), true, true
+	}
+
+	return 0, false, false
+}
+
+// JoinPath appends a field name to a path.
+func JoinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
+
+// Lookup returns the value at a path produced by Validate.
+func Lookup(rv reflect.Value, path string) (reflect.Value, bool) {
+	for path != "" {
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+
+		var segment string
+		if path[0] == '[' {
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return reflect.Value{}, false
+			}
+			segment, path = path[1:end], path[end+1:]
+			switch rv.Kind() {
+			case reflect.Slice, reflect.Array:
+				i, err := strconv.Atoi(segment)
+				if err != nil || i < 0 || i >= rv.Len() {
+					return reflect.Value{}, false
+				}
+				rv = rv.Index(i)
+			case reflect.Map:
+				found := false
+				iter := rv.MapRange()
+				for iter.Next() {
+					if fmt.Sprint(iter.Key().Interface()) == segment {
+						rv = iter.Value()
+						found = true
+						break
+					}
+				}
+				if !found {
+					return reflect.Value{}, false
+				}
+			default:
+				return reflect.Value{}, false
+			}
+			continue
+		}
+
+		path = strings.TrimPrefix(path, ".")
+		end := strings.IndexAny(path, ".[")
+		if end < 0 {
+			end = len(path)
+		}
+		segment, path = path[:end], path[end:]
+		if rv.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		rv = rv.FieldByName(segment)
+		if !rv.IsValid() {
+			return reflect.Value{}, false
+		}
+	}
+
+	return rv, true
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package validate_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config/validate"
+)
+
+type server struct {
+	Host string   `validate:"required"`
+	Port int      `validate:"min=1,max=65535"`
+	Mode string   `validate:"oneof=dev prod"`
+	Tags []string `validate:"max=2"`
+}
+
+type cluster struct {
+	Name    string `validate:"len=4"`
+	Servers []server
+	Backup  *server
+	Ignored server `validate:"-"`
+}
+
+func (c cluster) Validate() error {
+	if len(c.Servers) == 0 {
+		return errors.New("at least one server is required")
+	}
+
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	valid := cluster{
+		Name: "main",
+		Servers: []server{
+			{Host: "localhost", Port: 80, Mode: "dev"},
+		},
+	}
+	require.NoError(t, validate.Validate(valid))
+	require.NoError(t, validate.Validate(&valid))
+
+	invalid := cluster{
+		Name: "secondary",
+		Servers: []server{
+			{Host: "", Port: 70000, Mode: "test", Tags: []string{"a", "b", "c"}},
+		},
+		Backup: &server{Host: "backup", Port: 0, Mode: "prod"},
+	}
+	err := validate.Validate(invalid)
+	require.Error(t, err)
+
+	errs := err.(validate.Errors)
+	paths := make(map[string]string)
+	for _, fe := range errs {
+		paths[fe.Path] = fe.Rule
+	}
+	require.Equal(t, map[string]string{
+		"Name":            "len",
+		"Servers[0].Host": "required",
+		"Servers[0].Port": "max",
+		"Servers[0].Mode": "oneof",
+		"Servers[0].Tags": "max",
+		"Backup.Port":     "min",
+	}, paths)
+
+	err = validate.Validate(cluster{Name: "none"})
+	require.EqualError(t, err, "at least one server is required")
+}
+
+func TestLookup(t *testing.T) {
+	c := cluster{
+		Servers: []server{{Host: "localhost"}},
+		Backup:  &server{Port: 5},
+	}
+
+	v, found := validate.Lookup(reflect.ValueOf(&c), "Servers[0].Host")
+	require.True(t, found)
+	require.Equal(t, "localhost", v.Interface())
+
+	v, found = validate.Lookup(reflect.ValueOf(&c), "Backup.Port")
+	require.True(t, found)
+	require.Equal(t, 5, v.Interface())
+
+	_, found = validate.Lookup(reflect.ValueOf(&c), "Servers[1].Host")
+	require.False(t, found)
+}
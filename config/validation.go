@@ -0,0 +1,166 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/tamasd/constellation/config/validate"
+)
+
+// Describer is implemented by providers that can tell where the value of a
+// key comes from, e.g. "file:config/foo/db.yaml".
+type Describer interface {
+	Describe(key string) string
+}
+
+// FieldDescriber is implemented by providers that can tell where the value
+// of a single field comes from, e.g. "env:NS_FOO_DB_HOST". The type is the
+// type of the schema of the key.
+type FieldDescriber interface {
+	DescribeField(key string, t reflect.Type, path string) string
+}
+
+type layer struct {
+	provider Provider
+	source   string
+	value    reflect.Value
+}
+
+func (l layer) describeField(key, path string) string {
+	if fd, ok := l.provider.(FieldDescriber); ok && path != "" {
+		return fd.DescribeField(key, l.value.Type().Elem(), path)
+	}
+
+	return l.source
+}
+
+func describeProvider(p Provider, key string) string {
+	if d, ok := p.(Describer); ok {
+		return d.Describe(key)
+	}
+
+	return fmt.Sprintf("%T", p)
+}
+
+// ValidationError is returned when a config value fails validation.
+type ValidationError struct {
+	Namespace string
+	Key       string
+	Errors    []FieldValidationError
+}
+
+// FieldValidationError is a failed validation rule, along with the provider
+// that supplied the offending value.
+type FieldValidationError struct {
+	*validate.FieldError
+	Provider string
+}
+
+func (e FieldValidationError) Error() string {
+	if e.Provider == "" {
+		return e.FieldError.Error()
+	}
+
+	return e.FieldError.Error() + " (provided by " + e.Provider + ")"
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+
+	name := e.Key
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + e.Key
+	}
+
+	return "invalid config " + name + ": " + strings.Join(msgs, "; ")
+}
+
+// withNamespace returns a copy of an error with the namespace set. The
+// error is copied, because it can be shared by concurrent loads of a key.
+func withNamespace(err error, namespace string) error {
+	switch e := err.(type) {
+	case *ValidationError:
+		c := *e
+		c.Namespace = namespace
+		return &c
+	case *KeyNotFoundError:
+		c := *e
+		c.Namespace = namespace
+		return &c
+	case *ConflictError:
+		c := *e
+		c.Namespace = namespace
+		return &c
+	}
+
+	return err
+}
+
+func validateValue(key string, v reflect.Value, layers []layer) error {
+	err := validate.Validate(v.Interface())
+	if err == nil {
+		return nil
+	}
+
+	errs, ok := err.(validate.Errors)
+	if !ok {
+		return err
+	}
+
+	verr := &ValidationError{Key: key}
+	for _, fe := range errs {
+		verr.Errors = append(verr.Errors, FieldValidationError{
+			FieldError: fe,
+			Provider:   fieldSource(layers, key, fe.Path),
+		})
+	}
+
+	return verr
+}
+
+// fieldSource finds the layer that supplied the value of a field.
+//
+// Layers are merged in order, and the first non-zero value wins.
+func fieldSource(layers []layer, key, path string) string {
+	if path == "" {
+		if len(layers) == 1 {
+			return layers[0].source
+		}
+		return ""
+	}
+
+	for _, l := range layers {
+		if op, ok := l.provider.(overridingProvider); ok && op.isSet(key, path) {
+			return l.describeField(key, path)
+		}
+		fv, found := validate.Lookup(l.value, path)
+		if found && !fv.IsZero() {
+			return l.describeField(key, path)
+		}
+	}
+
+	return ""
+}
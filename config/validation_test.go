@@ -0,0 +1,89 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+	"github.com/tamasd/constellation/util"
+)
+
+type validatedTest struct {
+	Host string `validate:"required"`
+	Port int    `validate:"min=1,max=65535"`
+}
+
+func TestValidation(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, "server.json"), []byte(`{"Host": "localhost", "Port": 70000}`), 0644))
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("server", reflect.TypeOf(validatedTest{}))
+
+	mp := config.NewMemoryConfigProvider()
+	dp := config.NewDirectoryConfigProvider(tmpdir, true)
+	registerFileTypes(dp)
+	collection := config.NewCollection()
+	collection.AddProviders(mp, dp)
+	c.AddCollection("config", collection)
+
+	t.Run("invalid value names the field and the provider", func(t *testing.T) {
+		_, err := c.Get("config").Get("server")
+		require.Error(t, err)
+
+		verr, ok := err.(*config.ValidationError)
+		require.True(t, ok)
+		require.Equal(t, "config", verr.Namespace)
+		require.Equal(t, "server", verr.Key)
+		require.Len(t, verr.Errors, 1)
+		require.Equal(t, "Port", verr.Errors[0].Path)
+		require.Equal(t, "max", verr.Errors[0].Rule)
+		require.Equal(t, "file:"+filepath.ToSlash(filepath.Join(tmpdir, "server.json")), verr.Errors[0].Provider)
+	})
+
+	t.Run("higher precedence provider fixes the value", func(t *testing.T) {
+		require.NoError(t, mp.Save("server", validatedTest{Port: 8080}))
+		v, err := c.Get("config").Get("server")
+		require.NoError(t, err)
+		require.Equal(t, validatedTest{Host: "localhost", Port: 8080}, v)
+	})
+
+	t.Run("invalid values are not saved", func(t *testing.T) {
+		_, saver, err := c.GetWritable("config").GetWritable("server")
+		require.NoError(t, err)
+
+		err = saver.Save(validatedTest{Port: 8080})
+		require.Error(t, err)
+		verr, ok := err.(*config.ValidationError)
+		require.True(t, ok)
+		require.Equal(t, "Host", verr.Errors[0].Path)
+		require.Equal(t, "required", verr.Errors[0].Rule)
+	})
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ChangeNotifier is implemented by providers that can report changed keys.
+type ChangeNotifier interface {
+	OnChange(f func(key string))
+}
+
+// Poller is implemented by providers that detect changes by polling their
+// backing storage.
+type Poller interface {
+	Poll() error
+}
+
+type changeListeners struct {
+	mtx       sync.RWMutex
+	listeners []func(key string)
+}
+
+func (l *changeListeners) OnChange(f func(key string)) {
+	l.mtx.Lock()
+	l.listeners = append(l.listeners, f)
+	l.mtx.Unlock()
+}
+
+func (l *changeListeners) notify(key string) {
+	l.mtx.RLock()
+	listeners := l.listeners
+	l.mtx.RUnlock()
+
+	for _, f := range listeners {
+		f(key)
+	}
+}
+
+type watcher struct {
+	mtx  sync.Mutex
+	f    func(old, new interface{})
+	last interface{}
+}
+
+func (w *watcher) update(v interface{}) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if reflect.DeepEqual(w.last, v) {
+		return
+	}
+
+	old := w.last
+	w.last = v
+	w.f(old, v)
+}
+
+// Watch calls f every time the value of a key changes in a namespace.
+//
+// The returned function cancels the subscription.
+func (s *Store) Watch(namespace, key string, f func(old, new interface{})) (func(), error) {
+	current, err := s.get(namespace, key)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		f:    f,
+		last: current,
+	}
+
+	s.watchMtx.Lock()
+	if s.watchers[namespace] == nil {
+		s.watchers[namespace] = make(map[string][]*watcher)
+	}
+	s.watchers[namespace][key] = append(s.watchers[namespace][key], w)
+	s.watchMtx.Unlock()
+
+	return func() {
+		s.watchMtx.Lock()
+		defer s.watchMtx.Unlock()
+
+		watchers := s.watchers[namespace][key]
+		for i, item := range watchers {
+			if item == w {
+				s.watchers[namespace][key] = append(watchers[:i:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(s.watchers[namespace][key]) == 0 {
+			delete(s.watchers[namespace], key)
+		}
+	}, nil
+}
+
+// Invalidate evicts a key from the cache of a namespace and notifies the
+// watchers of the key.
+func (s *Store) Invalidate(namespace, key string) {
+	s.mtx.RLock()
+	collection, exists := s.namespaces[namespace]
+	s.mtx.RUnlock()
+
+	if exists {
+		collection.Invalidate(key)
+	} else {
+		s.notify(namespace, key)
+	}
+}
+
+// Poll checks the providers of every loaded namespace for changes.
+func (s *Store) Poll() {
+	s.mtx.RLock()
+	namespaces := make(map[string]*Collection, len(s.namespaces))
+	for namespace, collection := range s.namespaces {
+		namespaces[namespace] = collection
+	}
+	s.mtx.RUnlock()
+
+	for namespace, collection := range namespaces {
+		if err := collection.Poll(); err != nil {
+			s.logger.
+				WithError(err).
+				WithField("namespace", namespace).
+				Warn("config poll error")
+		}
+	}
+}
+
+// PollChanges calls Poll periodically until the context is canceled.
+func (s *Store) PollChanges(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Poll()
+		}
+	}
+}
+
+func (s *Store) attach(namespace string, collection *Collection) {
+	collection.setChangeHandler(func(key string) {
+		s.notify(namespace, key)
+	})
+	collection.setSecretResolvers(s.secrets)
+
+	s.mtx.RLock()
+	overrides := s.overrides
+	s.mtx.RUnlock()
+	collection.prependOverrides(overrides)
+}
+
+func (s *Store) notify(namespace, key string) {
+	s.watchMtx.Lock()
+	watchers := append([]*watcher(nil), s.watchers[namespace][key]...)
+	s.watchMtx.Unlock()
+
+	if len(watchers) == 0 {
+		return
+	}
+
+	v, err := s.get(namespace, key)
+	if err != nil {
+		s.logger.
+			WithError(err).
+			WithField("namespace", namespace).
+			WithField("key", key).
+			Warn("failed to reload changed config")
+		return
+	}
+
+	for _, w := range watchers {
+		w.update(v)
+	}
+}
+
+// Invalidate evicts a key from the cache and notifies the store about the
+// change.
+func (c *Collection) Invalidate(key string) {
+	c.mtx.Lock()
+	delete(c.cache, key)
+	c.generation++
+	c.mtx.Unlock()
+
+	c.notify(key)
+}
+
+// Poll checks every polling provider of the collection for changes.
+func (c *Collection) Poll() error {
+	var firstErr error
+	for _, provider := range c.getProviders() {
+		if p, ok := provider.(Poller); ok {
+			if err := p.Poll(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+func (c *Collection) setChangeHandler(f func(key string)) {
+	c.mtx.Lock()
+	c.onChange = f
+	c.mtx.Unlock()
+}
+
+func (c *Collection) notify(key string) {
+	c.mtx.RLock()
+	f := c.onChange
+	c.mtx.RUnlock()
+
+	if f != nil {
+		f(key)
+	}
+}
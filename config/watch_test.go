@@ -0,0 +1,188 @@
+/*
+ * Copyright Tamás Demeter-Haludka 2021
+ *
+ * This file is part of Constellation.
+ *
+ * Constellation is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Constellation is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Constellation.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamasd/constellation/config"
+	"github.com/tamasd/constellation/logger/null"
+	"github.com/tamasd/constellation/util"
+)
+
+type watchEvent struct {
+	old interface{}
+	new interface{}
+}
+
+func recordChanges(t *testing.T, c *config.Store, namespace, key string) (*[]watchEvent, func()) {
+	var events []watchEvent
+	cancel, err := c.Watch(namespace, key, func(old, new interface{}) {
+		events = append(events, watchEvent{old, new})
+	})
+	require.NoError(t, err)
+
+	return &events, cancel
+}
+
+func TestWatchMemoryProvider(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test", reflect.TypeOf(test{}))
+
+	mp := config.NewMemoryConfigProvider()
+	collection := config.NewCollection()
+	collection.AddProviders(mp)
+	c.AddCollection("config", collection)
+
+	events, cancel := recordChanges(t, c, "config", "test")
+
+	example := testExample()
+	require.NoError(t, mp.Save("test", example))
+	require.Equal(t, []watchEvent{{nil, example}}, *events)
+
+	v, err := c.Get("config").Get("test")
+	require.NoError(t, err)
+	require.Equal(t, example, v)
+
+	t.Run("saving the same value does not notify", func(t *testing.T) {
+		require.NoError(t, mp.Save("test", example))
+		require.Len(t, *events, 1)
+	})
+
+	t.Run("saving through the store notifies", func(t *testing.T) {
+		_, saver, err := c.GetWritable("config").GetWritable("test")
+		require.NoError(t, err)
+		changed := example
+		changed.B = "qwer"
+		require.NoError(t, saver.Save(changed))
+		require.Len(t, *events, 2)
+		require.Equal(t, watchEvent{example, changed}, (*events)[1])
+	})
+
+	t.Run("canceled watchers are not notified", func(t *testing.T) {
+		cancel()
+		require.NoError(t, mp.Save("test", testExample()))
+		require.Len(t, *events, 2)
+	})
+}
+
+func TestWatchDirectoryProvider(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	fn := filepath.Join(tmpdir, "test.json")
+	require.NoError(t, ioutil.WriteFile(fn, []byte(`{"A": 5}`), 0644))
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test", reflect.TypeOf(test{}))
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, true)
+	registerFileTypes(dp)
+	collection := config.NewCollection()
+	collection.AddProviders(dp)
+	c.AddCollection("config", collection)
+
+	events, cancel := recordChanges(t, c, "config", "test")
+	defer cancel()
+
+	c.Poll()
+	require.Empty(t, *events)
+
+	require.NoError(t, ioutil.WriteFile(fn, []byte(`{"A": 6, "B": "asdf"}`), 0644))
+	modified := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(fn, modified, modified))
+
+	c.Poll()
+	require.Len(t, *events, 1)
+	require.Equal(t, 5, (*events)[0].old.(test).A)
+	require.Equal(t, 6, (*events)[0].new.(test).A)
+
+	v, err := c.Get("config").Get("test")
+	require.NoError(t, err)
+	require.Equal(t, "asdf", v.(test).B)
+}
+
+func TestPollWhileAddingOverrides(t *testing.T) {
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test", reflect.TypeOf(test{}))
+	collection := config.NewCollection()
+	collection.AddProviders(config.NewMemoryConfigProvider())
+	c.AddCollection("config", collection)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.Poll()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		c.AddOverrides(config.NewMemoryConfigProvider())
+	}
+	<-done
+
+	_, err := c.Get("config").Get("test")
+	require.NoError(t, err)
+}
+
+func TestWatchDirectoryProviderIncludes(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "constellationtest")
+	require.NoError(t, err)
+	defer func() { util.Must(os.RemoveAll(tmpdir)) }()
+
+	included := filepath.Join(tmpdir, "included.json")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, "test.json"), []byte(`{"$include": "included.json"}`), 0644))
+	require.NoError(t, ioutil.WriteFile(included, []byte(`{"A": 5}`), 0644))
+
+	c := config.NewStore(null.NewLogger())
+	c.RegisterSchema("test", reflect.TypeOf(test{}))
+
+	dp := config.NewDirectoryConfigProvider(tmpdir, true)
+	registerFileTypes(dp)
+	collection := config.NewCollection()
+	collection.AddProviders(dp)
+	c.AddCollection("config", collection)
+
+	events, cancel := recordChanges(t, c, "config", "test")
+	defer cancel()
+
+	c.Poll()
+	require.Empty(t, *events)
+
+	require.NoError(t, ioutil.WriteFile(included, []byte(`{"A": 6}`), 0644))
+	modified := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(included, modified, modified))
+
+	c.Poll()
+	require.Len(t, *events, 1)
+	require.Equal(t, 5, (*events)[0].old.(test).A)
+	require.Equal(t, 6, (*events)[0].new.(test).A)
+
+	c.Poll()
+	require.Len(t, *events, 1)
+}
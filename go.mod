@@ -4,7 +4,9 @@ go 1.16
 
 require (
 	github.com/golang/protobuf v1.5.1 // indirect
+	github.com/hashicorp/hcl v1.0.0
 	github.com/imdario/mergo v0.3.12
+	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.0
 	github.com/nats-io/nats-server/v2 v2.2.0 // indirect
 	github.com/nats-io/nats-streaming-server v0.21.1 // indirect
@@ -14,6 +16,10 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.7.1
-	github.com/stretchr/testify v1.7.0
+	// json5 requires otto, which requires testify v1.8.1
+	github.com/stretchr/testify v1.8.1
+	github.com/titanous/json5 v1.0.0
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f
+	gopkg.in/ini.v1 v1.67.0
 	gopkg.in/yaml.v2 v2.4.0
 )